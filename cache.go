@@ -0,0 +1,274 @@
+package twitter
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Cache stores the responses of user and tweet lookups. Get returns false if the key is missing
+// or expired. Implementations must be safe for concurrent use.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte, ttl time.Duration)
+}
+
+// CacheTTL holds the time to live of cached responses for each object type.
+// Zero uses the default TTL of the object type, a negative TTL disables caching it.
+type CacheTTL struct {
+	// Users (default: 1 hour) is the time to live of user lookups
+	Users time.Duration
+	// Tweets (default: 15 minutes) is the time to live of tweet lookups
+	Tweets time.Duration
+}
+
+// Default time to live of cached responses
+const (
+	DefaultUsersTTL  = time.Hour
+	DefaultTweetsTTL = 15 * time.Minute
+)
+
+// Cached object types
+const (
+	cacheUsers = iota
+	cacheTweets
+)
+
+// cachedEndpoints holds the object type of each cached endpoint template
+var cachedEndpoints = map[string]int{
+	"/users":                       cacheUsers,
+	"/users/by":                    cacheUsers,
+	"/users/:id":                   cacheUsers,
+	"/users/by/username/:username": cacheUsers,
+	"/tweets":                      cacheTweets,
+	"/tweets/:id":                  cacheTweets,
+}
+
+// WithCache caches the responses of user and tweet lookups (GetUsers, GetUsersBy, GetUserByID,
+// GetUsersByUserName, GetTweets and GetTweetByID) in cache, keyed by the normalized request url.
+// Cache hits are returned immediately, without waiting for the rate limit budget of the endpoint.
+func WithCache(cache Cache, ttl CacheTTL) ClientOption {
+	return func(api *Twitter) {
+		api.cache = cache
+		api.cacheTTL = ttl
+	}
+}
+
+// cacheKey returns the cache key and the time to live of the request's response,
+// or a zero TTL if the response must not be cached.
+func (api *Twitter) cacheKey(req *Request) (string, time.Duration) {
+	if api.cache == nil || req.Req.Method != "GET" {
+		return "", 0
+	}
+
+	kind, ok := cachedEndpoints[req.Endpoint]
+	if !ok {
+		return "", 0
+	}
+
+	ttl := api.cacheTTL.Users
+	if ttl == 0 {
+		ttl = DefaultUsersTTL
+	}
+	if kind == cacheTweets {
+		ttl = api.cacheTTL.Tweets
+		if ttl == 0 {
+			ttl = DefaultTweetsTTL
+		}
+	}
+	if ttl < 0 {
+		return "", 0
+	}
+
+	// query values are sorted by key once encoded, responses differ per auth context
+	u := *req.Req.URL
+	u.RawQuery = u.Query().Encode()
+	return api.auth + " " + u.String(), ttl
+}
+
+// loadCache writes the cached response of the request, if any, to
+// the request's results and reports whether it was found.
+func (api *Twitter) loadCache(req *Request) bool {
+	key, ttl := api.cacheKey(req)
+	if ttl <= 0 {
+		return false
+	}
+
+	b, ok := api.cache.Get(key)
+	if !ok {
+		return false
+	}
+
+	var results Data
+	if err := json.Unmarshal(b, &results); err != nil {
+		return false
+	}
+
+	req.Results = results
+	req.RateLimit = RateLimitInfo{}
+	return true
+}
+
+// storeCache caches the request's results.
+func (api *Twitter) storeCache(req *Request) {
+	key, ttl := api.cacheKey(req)
+	if ttl <= 0 {
+		return
+	}
+
+	if b, err := json.Marshal(req.Results); err == nil {
+		api.cache.Set(key, b, ttl)
+	}
+}
+
+// lruEntry is an entry of the MemoryCache
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// MemoryCache is an in-memory Cache, evicting the least recently used entries
+// once it holds more than its capacity.
+type MemoryCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	lru      *list.List
+}
+
+// NewMemoryCache returns a new MemoryCache holding up to capacity entries.
+func NewMemoryCache(capacity int) *MemoryCache {
+	return &MemoryCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Get implements Cache
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := el.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(el)
+	return entry.value, true
+}
+
+// Set implements Cache
+func (c *MemoryCache) Set(key string, value []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &lruEntry{key: key, value: value, expires: time.Now().Add(ttl)}
+	if el, ok := c.entries[key]; ok {
+		el.Value = entry
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(entry)
+
+	// evict the least recently used entries
+	for c.capacity > 0 && c.lru.Len() > c.capacity {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.entries, el.Value.(*lruEntry).key)
+	}
+}
+
+// Len returns the number of entries in the cache, including expired ones.
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+// diskEntry is an entry of the DiskCache
+type diskEntry struct {
+	Value   []byte    `json:"value"`
+	Expires time.Time `json:"expires"`
+}
+
+// DiskCache is an on-disk Cache, keeping each entry in a file of its directory,
+// so that cached responses are shared across processes and restarts.
+type DiskCache struct {
+	dir string
+}
+
+// NewDiskCache returns a new DiskCache in dir, creating the directory if needed.
+func NewDiskCache(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &DiskCache{dir: dir}, nil
+}
+
+// path returns the file of the key
+func (c *DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// Get implements Cache
+func (c *DiskCache) Get(key string) ([]byte, bool) {
+	b, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+
+	var entry diskEntry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return nil, false
+	}
+
+	if time.Now().After(entry.Expires) {
+		os.Remove(c.path(key))
+		return nil, false
+	}
+
+	return entry.Value, true
+}
+
+// Set implements Cache. Entries are replaced atomically, errors are ignored.
+func (c *DiskCache) Set(key string, value []byte, ttl time.Duration) {
+	b, err := json.Marshal(diskEntry{Value: value, Expires: time.Now().Add(ttl)})
+	if err != nil {
+		return
+	}
+
+	tmp, err := ioutil.TempFile(c.dir, ".tmp-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		return
+	}
+
+	os.Rename(tmp.Name(), c.path(key))
+}
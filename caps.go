@@ -0,0 +1,146 @@
+package twitter
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// WithMaxPages (default: unlimited) stops the pagination once n pages are returned, closing the channels.
+func WithMaxPages(n int) QueueOption {
+	return func(q *Queue) {
+		q.maxPages = n
+	}
+}
+
+// WithUntil (default: none) stops the pagination once tweets created before t appear, closing the
+// channels. The tweets created before t are dropped from the page, and `created_at` is added to the
+// `tweet.fields` parameter if missing. It applies to the endpoints returning tweets.
+func WithUntil(t time.Time) QueueOption {
+	return func(q *Queue) {
+		q.until = t
+	}
+}
+
+// tweetEndpoints holds the endpoint templates returning a list of tweets
+var tweetEndpoints = map[string]bool{
+	"/users/:id/mentions":   true,
+	"/users/:id/tweets":     true,
+	"/tweets":               true,
+	"/tweets/search/recent": true,
+	"/tweets/search/all":    true,
+}
+
+// prepare sets the parameters the queue's caps need on the request, given the progress so far.
+func (q *Queue) prepare(req *Request, checkpoint *Checkpoint) {
+	q.limitResults(req, checkpoint.Results)
+
+	if q.until.IsZero() || !tweetEndpoints[req.Endpoint] {
+		return
+	}
+
+	// the creation time of tweets is needed to compare it with until
+	fields := req.Req.URL.Query().Get("tweet.fields")
+	for _, f := range strings.Split(fields, ",") {
+		if f == "created_at" {
+			return
+		}
+	}
+	if fields != "" {
+		fields += ","
+	}
+	req.UpdateURLValues(url.Values{"tweet.fields": {fields + "created_at"}})
+}
+
+// capped applies the queue's caps to the results of a page, dropping the results past them,
+// and advances the checkpoint. It reports whether the pagination must stop.
+func (q *Queue) capped(results *Data, checkpoint *Checkpoint) bool {
+	stop := false
+
+	if !q.until.IsZero() || q.maxResults > 0 {
+		if objects, ok := results.objects(); ok {
+			n := len(objects)
+
+			// drop the tweets created before until
+			if !q.until.IsZero() {
+				objects = createdSince(objects, q.until)
+			}
+
+			// drop the results past the max results
+			objects = q.dropResults(objects, checkpoint.Results)
+
+			if len(objects) < n {
+				results.setObjects(objects)
+				stop = true
+			}
+		}
+	}
+
+	checkpoint.advance(*results)
+
+	return stop || q.reached(checkpoint)
+}
+
+// reached reports whether the progress of the checkpoint reached the queue's max pages or results,
+// e.g. when resuming the checkpoint of a crawl that was stopped by a lower cap.
+func (q *Queue) reached(checkpoint *Checkpoint) bool {
+	return (q.maxPages > 0 && checkpoint.Pages >= q.maxPages) ||
+		(q.maxResults > 0 && checkpoint.Results >= q.maxResults)
+}
+
+// createdSince returns the objects created at or after t, keeping the ones without a creation time.
+func createdSince(objects []json.RawMessage, t time.Time) []json.RawMessage {
+	kept := objects[:0:0]
+	for _, o := range objects {
+		var object struct {
+			CreatedAt string `json:"created_at"`
+		}
+		json.Unmarshal(o, &object)
+
+		created, err := time.Parse(time.RFC3339Nano, object.CreatedAt)
+		if err == nil && created.Before(t) {
+			continue
+		}
+		kept = append(kept, o)
+	}
+	return kept
+}
+
+// objects returns the list of objects of the results, if the data is a list.
+func (d *Data) objects() ([]json.RawMessage, bool) {
+	if d.Data == nil {
+		return nil, false
+	}
+
+	b, err := json.Marshal(d.Data)
+	if err != nil {
+		return nil, false
+	}
+
+	var objects []json.RawMessage
+	if err := json.Unmarshal(b, &objects); err != nil {
+		return nil, false
+	}
+	return objects, true
+}
+
+// setObjects replaces the list of objects of the results, updating the result count.
+func (d *Data) setObjects(objects []json.RawMessage) {
+	var data interface{}
+	if len(objects) > 0 {
+		b, _ := json.Marshal(objects)
+		json.Unmarshal(b, &data)
+		d.Data = &data
+	} else {
+		d.Data = nil
+	}
+
+	// the meta may be shared with other requests
+	var meta Meta
+	if d.Meta != nil {
+		meta = *d.Meta
+	}
+	meta.ResultCount = len(objects)
+	d.Meta = &meta
+}
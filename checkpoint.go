@@ -0,0 +1,249 @@
+package twitter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrCheckpointNotFound is returned by a CheckpointStore when there is no checkpoint for the key.
+var ErrCheckpointNotFound = errors.New("checkpoint store: checkpoint not found")
+
+// ErrCheckpointMismatch is returned when resuming from the checkpoint of another request,
+// i.e. of a different endpoint, url or parameters.
+var ErrCheckpointMismatch = errors.New("twitter: checkpoint of a different request")
+
+// Checkpoint holds the progress of a paginated crawl, to resume it from the next page.
+type Checkpoint struct {
+	// Endpoint is the endpoint template of the crawl, e.g. `/users/:id/followers`
+	Endpoint string `json:"endpoint"`
+	// URL is the url of the crawl's requests, without the pagination token
+	URL string `json:"url"`
+	// Params are the query parameters of the crawl's requests, without the pagination token
+	Params url.Values `json:"params"`
+	// NextToken is the token of the next page
+	NextToken string `json:"next_token"`
+	// Pages is the number of pages returned so far
+	Pages int `json:"pages"`
+	// Results is the number of results returned so far
+	Results int `json:"results"`
+	// UpdatedAt is the time the checkpoint was saved
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CheckpointStore persists the checkpoints of paginated crawls, so that they outlive the process.
+// Load returns ErrCheckpointNotFound if there is no checkpoint for the key.
+type CheckpointStore interface {
+	Load(key string) (*Checkpoint, error)
+	Save(key string, checkpoint *Checkpoint) error
+	Delete(key string) error
+}
+
+// WithCheckpoint persists the progress of the pagination to store under key, after each page.
+// If the store holds a checkpoint for key, the pagination resumes from its next page, as long as the
+// checkpoint is of the same request, otherwise ErrCheckpointMismatch is returned. The checkpoint is saved
+// before the page is returned, so pages are never returned twice, even after a crash. Once the pagination
+// is done, the checkpoint is deleted.
+func WithCheckpoint(store CheckpointStore, key string) QueueOption {
+	return func(q *Queue) {
+		q.checkpoints = store
+		q.checkpointKey = key
+	}
+}
+
+// resume loads the queue's checkpoint, if any, setting the request's parameters and pagination
+// token to continue from the next page. It returns the checkpoint, or a new one to start with.
+func (q *Queue) resume(req *Request, tokenParam string) (*Checkpoint, error) {
+	params := req.Req.URL.Query()
+	params.Del(tokenParam)
+
+	u := *req.Req.URL
+	u.RawQuery = ""
+
+	checkpoint := &Checkpoint{Endpoint: req.Endpoint, URL: u.String(), Params: params}
+	if q.checkpoints == nil || tokenParam == "" {
+		return checkpoint, nil
+	}
+
+	stored, err := q.checkpoints.Load(q.checkpointKey)
+	if errors.Is(err, ErrCheckpointNotFound) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the checkpoint must be of the same request, e.g. not of another user's followers
+	if stored.Endpoint != checkpoint.Endpoint || stored.URL != checkpoint.URL || stored.Params.Encode() != params.Encode() {
+		return nil, ErrCheckpointMismatch
+	}
+
+	// continue with the parameters of the crawl
+	query := url.Values{}
+	for k, v := range stored.Params {
+		query[k] = v
+	}
+	query.Set(tokenParam, stored.NextToken)
+	req.Req.URL.RawQuery = query.Encode()
+
+	return stored, nil
+}
+
+// checkpoint saves the progress of the pagination, or deletes the checkpoint once there is no next page.
+func (q *Queue) checkpoint(checkpoint *Checkpoint, tokenParam, nextToken string) error {
+	if q.checkpoints == nil || tokenParam == "" {
+		return nil
+	}
+
+	if nextToken == "" {
+		err := q.checkpoints.Delete(q.checkpointKey)
+		if errors.Is(err, ErrCheckpointNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	checkpoint.NextToken = nextToken
+	checkpoint.UpdatedAt = q.clock.Now()
+	return q.checkpoints.Save(q.checkpointKey, checkpoint)
+}
+
+// advance updates the checkpoint with the page's results
+func (c *Checkpoint) advance(results Data) {
+	c.Pages++
+	if results.Meta != nil {
+		c.Results += results.Meta.ResultCount
+	}
+}
+
+// MemoryCheckpointStore keeps the checkpoints in memory, mostly useful for tests.
+type MemoryCheckpointStore struct {
+	mu          sync.Mutex
+	checkpoints map[string]Checkpoint
+}
+
+// NewMemoryCheckpointStore returns a new, empty, MemoryCheckpointStore
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{checkpoints: make(map[string]Checkpoint)}
+}
+
+// Load implements CheckpointStore
+func (s *MemoryCheckpointStore) Load(key string) (*Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.checkpoints[key]
+	if !ok {
+		return nil, ErrCheckpointNotFound
+	}
+	return &c, nil
+}
+
+// Save implements CheckpointStore
+func (s *MemoryCheckpointStore) Save(key string, checkpoint *Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checkpoints[key] = *checkpoint
+	return nil
+}
+
+// Delete implements CheckpointStore
+func (s *MemoryCheckpointStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.checkpoints, key)
+	return nil
+}
+
+// FileCheckpointStore keeps each checkpoint in a JSON file of its directory.
+type FileCheckpointStore struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// NewFileCheckpointStore returns a new FileCheckpointStore in dir, creating the directory if needed.
+func NewFileCheckpointStore(dir string) (*FileCheckpointStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileCheckpointStore{dir: dir}, nil
+}
+
+// path returns the file of the key
+func (s *FileCheckpointStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Load implements CheckpointStore
+func (s *FileCheckpointStore) Load(key string) (*Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := ioutil.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrCheckpointNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint := new(Checkpoint)
+	if err := json.Unmarshal(b, checkpoint); err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+// Save implements CheckpointStore. The file is replaced atomically and synced
+// to disk, so that the checkpoint survives a crash.
+func (s *FileCheckpointStore) Save(key string, checkpoint *Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path(key))
+}
+
+// Delete implements CheckpointStore
+func (s *FileCheckpointStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package twitter
+
+import "time"
+
+// Clock tells the time and creates timers for the clients, queues and streams, so that their
+// timing can be faked in tests. See twittertest.Clock for a fake implementation.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a timer created by a Clock, like time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// SystemClock is the Clock of the system, used by default.
+var SystemClock Clock = systemClock{}
+
+// systemClock implements Clock with the time package
+type systemClock struct{}
+
+// Now implements Clock
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTimer implements Clock
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+// systemTimer implements Timer with time.Timer
+type systemTimer struct {
+	*time.Timer
+}
+
+// C implements Timer
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// WithClock (default: SystemClock) sets the clock of the queue, used to pace, back off and
+// retry requests. The rate limit budgets are shared by the queues of the client, so the
+// queues of a client should use the same clock.
+func WithClock(clock Clock) QueueOption {
+	return func(q *Queue) {
+		q.clock = clock
+	}
+}
+
+// WithClientClock (default: SystemClock) sets the clock of the client, used by pools to pick the
+// client with rate limit budget for the requests that are not processed by a queue, such as
+// VerifyCredentials. A pool uses the clock of its first client.
+func WithClientClock(clock Clock) ClientOption {
+	return func(api *Twitter) {
+		api.clock = clock
+	}
+}
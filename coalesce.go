@@ -0,0 +1,274 @@
+package twitter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxBatchSize is the maximum number of ids of a lookup
+const maxBatchSize = 100
+
+// batchEndpoints holds the lookup endpoint template of each single id endpoint
+var batchEndpoints = map[string]string{
+	"/users/:id":  "/users",
+	"/tweets/:id": "/tweets",
+}
+
+// WithBatching merges the concurrent GetUserByID and GetTweetByID calls of the client, sent within
+// window of each other with the same parameters, into a single `/users?ids=` or `/tweets?ids=`
+// lookup of up to 100 ids, fanning the results back out to each caller. The includes of the lookup
+// are shared by all callers, while ids missing from the lookup return a not found *APIError.
+// The window is timed by the Clock of the queue starting the lookup, and paused queues only join
+// a lookup once resumed, while a lookup already sent is not paused along with its callers.
+func WithBatching(window time.Duration) ClientOption {
+	return func(api *Twitter) {
+		api.batcher = &batcher{window: window, batches: make(map[string]*batch)}
+	}
+}
+
+// dispatch sends the request on twitter api, sharing the call with identical
+// in-flight requests of the client, or merging it into a batched lookup.
+func (q *Queue) dispatch(api *Twitter, req *Request) error {
+	if api.batcher != nil {
+		if _, ok := batchEndpoints[req.Endpoint]; ok && req.Req.Method == "GET" {
+			return api.batcher.do(q, api, req)
+		}
+	}
+
+	if api.flights == nil || req.Req.Method != "GET" {
+		return q.send(api, req)
+	}
+
+	return api.flights.do(q.ctx, api.auth+" "+normalizeURL(req.Req.URL), req, func() error {
+		return q.send(api, req)
+	})
+}
+
+// normalizeURL returns u with its query values sorted by key.
+func normalizeURL(u *url.URL) string {
+	c := *u
+	c.RawQuery = c.Query().Encode()
+	return c.String()
+}
+
+// flight is an in-flight request, shared by identical requests
+type flight struct {
+	done      chan struct{}
+	results   Data
+	rateLimit RateLimitInfo
+	err       error
+}
+
+// flightGroup deduplicates identical in-flight requests
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// newFlightGroup returns a new, empty, flightGroup
+func newFlightGroup() *flightGroup {
+	return &flightGroup{flights: make(map[string]*flight)}
+}
+
+// do calls send, unless an identical request is in-flight, in which case it waits for
+// its results. If the shared request was canceled, the request is sent on its own.
+func (g *flightGroup) do(ctx context.Context, key string, req *Request, send func() error) error {
+	g.mu.Lock()
+	if f, ok := g.flights[key]; ok {
+		g.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-f.done:
+		}
+
+		if errors.Is(f.err, context.Canceled) || errors.Is(f.err, context.DeadlineExceeded) {
+			return send()
+		}
+
+		req.Results, req.RateLimit = f.results, f.rateLimit
+		return f.err
+	}
+
+	f := &flight{done: make(chan struct{})}
+	g.flights[key] = f
+	g.mu.Unlock()
+
+	f.err = send()
+	f.results, f.rateLimit = req.Results, req.RateLimit
+
+	g.mu.Lock()
+	delete(g.flights, key)
+	g.mu.Unlock()
+	close(f.done)
+
+	return f.err
+}
+
+// batcher merges single id lookups into batched lookups
+type batcher struct {
+	mu      sync.Mutex
+	window  time.Duration
+	batches map[string]*batch
+}
+
+// batch is a pending lookup, holding the callers waiting on each id
+type batch struct {
+	queue   *Queue
+	req     *Request
+	ids     []string
+	waiters map[string][]chan batchResult
+}
+
+// batchResult is the result of a batched lookup for a single id
+type batchResult struct {
+	results   Data
+	rateLimit RateLimitInfo
+	err       error
+}
+
+// do adds the request's id to the pending lookup of its endpoint and parameters
+// and waits for the results, sending the lookup once the window ends or it's full.
+// Once a batch is sent, pausing the queues of its callers doesn't hold it back.
+func (b *batcher) do(q *Queue, api *Twitter, req *Request) error {
+	id := path.Base(req.Req.URL.Path)
+	key := req.Endpoint + " " + req.Req.URL.Query().Encode()
+	result := make(chan batchResult, 1)
+
+	// a paused queue doesn't join a batch until it's resumed
+	if !q.paused() {
+		return q.ctx.Err()
+	}
+
+	b.mu.Lock()
+	pending, ok := b.batches[key]
+	if !ok {
+		pending = b.newBatch(q, api, req)
+		b.batches[key] = pending
+
+		// the window is timed by the clock of the queue starting the batch
+		timer := q.clock.NewTimer(b.window)
+		go func() {
+			<-timer.C()
+			b.flush(api, key, pending)
+		}()
+	}
+	if _, ok := pending.waiters[id]; !ok {
+		pending.ids = append(pending.ids, id)
+	}
+	pending.waiters[id] = append(pending.waiters[id], result)
+	full := len(pending.ids) >= maxBatchSize
+	if full {
+		// the next ids start a new batch
+		delete(b.batches, key)
+	}
+	b.mu.Unlock()
+
+	if full {
+		go pending.send(api)
+	}
+
+	select {
+	case <-q.ctx.Done():
+		return q.ctx.Err()
+	case r := <-result:
+		req.Results, req.RateLimit = r.results, r.rateLimit
+		return r.err
+	}
+}
+
+// newBatch returns a new batch for the lookup endpoint of req, sent with the settings of q.
+func (b *batcher) newBatch(q *Queue, api *Twitter, req *Request) *batch {
+	endpoint := batchEndpoints[req.Endpoint]
+	lookup, _ := NewRquest("GET", api.baseURL+endpoint, req.Req.URL.Query(), nil)
+	lookup.Endpoint = endpoint
+
+	// the lookup outlives the callers, it's sent even if some of them are gone
+	queue := NewQueue(q.rate, q.delay, q.auto, nil, nil)
+	queue.throttle, queue.retry, queue.clock = q.throttle, q.retry, q.clock
+
+	return &batch{queue: queue, req: lookup, waiters: make(map[string][]chan batchResult)}
+}
+
+// flush sends the batch once its window ends, unless it was already sent full.
+func (b *batcher) flush(api *Twitter, key string, pending *batch) {
+	b.mu.Lock()
+	if b.batches[key] != pending {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.batches, key)
+	b.mu.Unlock()
+
+	pending.send(api)
+}
+
+// send sends the batch and fans the results out to the callers.
+func (pending *batch) send(api *Twitter) {
+	pending.req.UpdateURLValues(url.Values{"ids": {strings.Join(pending.ids, ",")}})
+	err := pending.queue.send(api, pending.req)
+
+	results := pending.split()
+	for id, waiters := range pending.waiters {
+		r := batchResult{rateLimit: pending.req.RateLimit, err: err}
+		if err == nil {
+			r.results, r.err = results[id].results, results[id].err
+		}
+		for _, w := range waiters {
+			w <- r
+		}
+	}
+}
+
+// split returns the results of each id of the batch.
+func (pending *batch) split() map[string]batchResult {
+	results := make(map[string]batchResult, len(pending.ids))
+
+	var objects []json.RawMessage
+	if pending.req.Results.Data != nil {
+		b, _ := json.Marshal(pending.req.Results.Data)
+		json.Unmarshal(b, &objects)
+	}
+
+	for _, o := range objects {
+		var object struct {
+			ID string `json:"id"`
+		}
+		json.Unmarshal(o, &object)
+
+		var data interface{}
+		json.Unmarshal(o, &data)
+		results[object.ID] = batchResult{results: Data{Data: &data, Includes: pending.req.Results.Includes}}
+	}
+
+	// the problems of the lookup, by id
+	problems := make(map[string]*Problem)
+	for _, p := range pending.req.Results.Errors {
+		problems[p.ResourceID] = p
+	}
+
+	for _, id := range pending.ids {
+		if _, ok := results[id]; !ok {
+			err := &APIError{
+				StatusCode: http.StatusNotFound,
+				Status:     http.StatusText(http.StatusNotFound),
+				URL:        pending.req.Req.URL.String(),
+				Detail:     "Could not find the object with id: [" + id + "].",
+			}
+			if p, ok := problems[id]; ok {
+				err.Title, err.Detail, err.Type, err.Errors = p.Title, p.Detail, p.Type, []*Problem{p}
+			}
+			results[id] = batchResult{err: err}
+		}
+	}
+
+	return results
+}
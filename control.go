@@ -0,0 +1,128 @@
+package twitter
+
+import (
+	"context"
+	"time"
+)
+
+// QueueStats holds the counters of a queue, as returned by Queue.Stats.
+type QueueStats struct {
+	// Pending is the number of requests waiting for rate limit budget, paused or in-flight
+	Pending int
+	// Sent is the number of requests sent on twitter api, including retries
+	Sent int
+	// Succeeded is the number of requests that succeeded
+	Succeeded int
+	// Failed is the number of requests that failed, after their retries
+	Failed int
+	// Retried is the number of retries
+	Retried int
+	// NextAllowed is the time the queue is allowed to send the next request
+	NextAllowed time.Time
+	// RateLimit is the rate limit information of the last response
+	RateLimit RateLimitInfo
+	// Paused reports whether the queue is paused
+	Paused bool
+	// Canceled reports whether the queue was canceled
+	Canceled bool
+}
+
+// WithQueueHook calls hook with the queue once it's created, to keep a reference to the queue of
+// a method, e.g. to report its Stats or to Pause it. The hook is called before any request is sent.
+func WithQueueHook(hook func(*Queue)) QueueOption {
+	return func(q *Queue) {
+		q.hooks = append(q.hooks, hook)
+	}
+}
+
+// Stats returns the counters of the queue. Requests coalesced with the identical
+// request of another queue are not counted.
+func (q *Queue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := q.stats
+	stats.Paused = q.resumed != nil
+	stats.Canceled = q.canceled
+	return stats
+}
+
+// Pause stops the queue from sending requests, until Resume is called.
+// In-flight requests are not affected.
+func (q *Queue) Pause() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.resumed == nil {
+		q.resumed = make(chan struct{})
+	}
+}
+
+// Resume resumes a paused queue.
+func (q *Queue) Resume() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.resumed != nil {
+		close(q.resumed)
+		q.resumed = nil
+	}
+}
+
+// Cancel stops the queue, canceling the in-flight request, as if its context was done.
+func (q *Queue) Cancel() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.canceled = true
+	q.cancel()
+}
+
+// bind binds the queue to ctx, until the returned function is called.
+func (q *Queue) bind(ctx context.Context) context.CancelFunc {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// release the previous context
+	q.cancel()
+	q.ctx, q.cancel = context.WithCancel(ctx)
+	if q.canceled {
+		q.cancel()
+	}
+	return q.cancel
+}
+
+// paused blocks while the queue is paused and reports whether
+// the queue's context is still alive afterwards.
+func (q *Queue) paused() bool {
+	for {
+		q.mu.Lock()
+		resumed := q.resumed
+		q.mu.Unlock()
+
+		if resumed == nil {
+			return q.ctx.Err() == nil
+		}
+
+		select {
+		case <-q.ctx.Done():
+			return false
+		case <-resumed:
+		}
+	}
+}
+
+// record updates the counters of the queue.
+func (q *Queue) record(update func(stats *QueueStats)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	update(&q.stats)
+}
+
+// allow records that the next request is allowed after d.
+func (q *Queue) allow(d time.Duration) {
+	q.record(func(stats *QueueStats) {
+		stats.NextAllowed = q.clock.Now().Add(d)
+	})
+}
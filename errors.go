@@ -0,0 +1,133 @@
+package twitter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Problem response object, as returned from Twitter API with each error,
+// either as the top level error or as an item of the `errors` array.
+// Official Documentation: https://developer.twitter.com/en/support/twitter-api/error-troubleshooting
+type Problem struct {
+	Title        string              `json:"title,omitempty"`
+	Detail       string              `json:"detail,omitempty"`
+	Type         string              `json:"type,omitempty"`
+	Value        string              `json:"value,omitempty"`
+	Parameter    string              `json:"parameter,omitempty"`
+	Parameters   map[string][]string `json:"parameters,omitempty"`
+	ResourceType string              `json:"resource_type,omitempty"`
+	ResourceID   string              `json:"resource_id,omitempty"`
+	Message      string              `json:"message,omitempty"`
+	Code         int                 `json:"code,omitempty"`
+}
+
+// APIError is returned for every non successful response from Twitter API.
+// It carries the HTTP status, the parsed problem details, the rate limit
+// information and the URL of the request.
+type APIError struct {
+	StatusCode int           `json:"-"`
+	Status     string        `json:"-"`
+	URL        string        `json:"-"`
+	RateLimit  RateLimitInfo `json:"-"`
+	RetryAfter time.Duration `json:"-"`
+	Title      string        `json:"title,omitempty"`
+	Detail     string        `json:"detail,omitempty"`
+	Type       string        `json:"type,omitempty"`
+	Errors     []*Problem    `json:"errors,omitempty"`
+}
+
+// Error returns the error message in the "<code> - <status>" form, followed
+// by the problem's detail if any.
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%d - %s", e.StatusCode, e.Status)
+
+	switch {
+	case e.Detail != "":
+		msg = fmt.Sprintf("%s: %s", msg, e.Detail)
+	case len(e.Errors) > 0 && e.Errors[0].Message != "":
+		msg = fmt.Sprintf("%s: %s", msg, e.Errors[0].Message)
+	}
+
+	return msg
+}
+
+// newAPIError creates an APIError from the response, reading the problem details
+// from the response body.
+func newAPIError(resp *http.Response) *APIError {
+	e := &APIError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		RateLimit:  parseRateLimit(resp.Header),
+		RetryAfter: parseRetryAfter(resp.Header, time.Now()),
+	}
+
+	if resp.Request != nil && resp.Request.URL != nil {
+		e.URL = resp.Request.URL.String()
+	}
+
+	// the body is optional, ignore any errors while reading it
+	if body, err := ioutil.ReadAll(resp.Body); err == nil {
+		json.Unmarshal(body, e)
+	}
+
+	return e
+}
+
+// errorCode returns the HTTP status code of err, or 0 if err is not an APIError.
+func errorCode(err error) int {
+	var e *APIError
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// parseRetryAfter returns the duration of the `Retry-After` header, either
+// in seconds or as an HTTP date, or 0 if it's missing or invalid.
+func parseRetryAfter(h http.Header, now time.Time) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+
+	if s, err := strconv.Atoi(v); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+
+	return 0
+}
+
+// IsRateLimited reports whether err is a rate limit error (420 or 429).
+func IsRateLimited(err error) bool {
+	code := errorCode(err)
+	return code == 420 || code == http.StatusTooManyRequests
+}
+
+// IsNotFound reports whether err is a not found error (404).
+func IsNotFound(err error) bool {
+	return errorCode(err) == http.StatusNotFound
+}
+
+// IsUnauthorized reports whether err is an authentication error (401).
+func IsUnauthorized(err error) bool {
+	return errorCode(err) == http.StatusUnauthorized
+}
+
+// IsForbidden reports whether err is an authorization error (403).
+func IsForbidden(err error) bool {
+	return errorCode(err) == http.StatusForbidden
+}
+
+// IsServerError reports whether err is a Twitter API server error (5xx).
+func IsServerError(err error) bool {
+	return errorCode(err) >= http.StatusInternalServerError
+}
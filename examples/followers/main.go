@@ -35,16 +35,14 @@ func main() {
 	// set tweet fields to return
 	v.Add("tweet.fields", "created_at,id,lang,source,public_metrics")
 
-	followers, _ := api.GetUserFollowers(*id, v, twitter.WithRate(15*time.Minute/15), twitter.WithAuto(true))
+	followers := api.GetUserFollowers(*id, v, twitter.WithRate(15*time.Minute/15), twitter.WithAuto(true))
 
-	for {
-		r, ok := <-followers
-
-		if !ok {
-			break
+	for r := range followers {
+		if r.Err != nil {
+			log.Fatalf("Page %d: %v", r.PageIndex, r.Err)
 		}
 
-		b, err := json.Marshal(r.Data)
+		b, err := json.Marshal(r.Page.Data)
 		if err != nil {
 			panic(err)
 		}
@@ -68,6 +66,6 @@ func main() {
 			)
 		}
 
-		log.Printf("Result Count: %d Next Token: %s\n", r.Meta.ResultCount, r.Meta.NextToken)
+		log.Printf("Result Count: %d Next Token: %s\n", r.Page.Meta.ResultCount, r.NextToken)
 	}
 }
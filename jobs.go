@@ -0,0 +1,499 @@
+package twitter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Job kinds, the paginated methods a JobQueue can run
+const (
+	JobUserFollowers = "followers"
+	JobUserFollowing = "following"
+	JobUserTweets    = "tweets"
+	JobUserMentions  = "mentions"
+)
+
+// jobKinds holds the paginator of each job kind
+var jobKinds = map[string]func(api *Twitter, id string, v url.Values, options ...QueueOption) *Paginator{
+	JobUserFollowers: (*Twitter).GetUserFollowersPaginator,
+	JobUserFollowing: (*Twitter).GetUserFollowingPaginator,
+	JobUserTweets:    (*Twitter).GetUserTweetsPaginator,
+	JobUserMentions:  (*Twitter).GetUserMentionsPaginator,
+}
+
+// Job states, as reported by JobQueue.Jobs
+const (
+	JobPending = "pending"
+	JobLeased  = "leased"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// ErrUnknownJobKind is returned when adding a job of an unknown kind.
+var ErrUnknownJobKind = errors.New("twitter: unknown job kind")
+
+// Job is a paginated crawl of a user, e.g. the followers of the user with UserID.
+type Job struct {
+	// ID identifies the job in the queue, defaults to `kind:user_id`
+	ID string `json:"id"`
+	// Kind is the method of the job, e.g. JobUserFollowers
+	Kind string `json:"kind"`
+	// UserID is the id of the user
+	UserID string `json:"user_id"`
+	// Params are the query parameters of the job's requests
+	Params url.Values `json:"params,omitempty"`
+}
+
+// JobPage is a page of a job, as passed to the JobHandler. Pages are identified by the
+// job's ID and Index, which stay the same if the page is delivered again.
+type JobPage struct {
+	Job   *Job
+	Index int
+	Data  *Data
+}
+
+// JobHandler processes the pages of the jobs. If it returns an error, the job
+// is released and the page is delivered again by the next Run.
+type JobHandler func(ctx context.Context, page *JobPage) error
+
+// JobStatus holds the state and progress of a job.
+type JobStatus struct {
+	Job *Job
+	// State is one of JobPending, JobLeased, JobDone or JobFailed
+	State string
+	// Pages is the number of pages completed so far
+	Pages int
+	// Results is the number of results completed so far
+	Results int
+	// Err is the error of a failed job
+	Err string
+}
+
+// jobRecord is an entry of the job queue's log
+type jobRecord struct {
+	// Op is one of add, lease, page, done or fail
+	Op         string      `json:"op"`
+	ID         string      `json:"id"`
+	Job        *Job        `json:"job,omitempty"`
+	Checkpoint *Checkpoint `json:"checkpoint,omitempty"`
+	Err        string      `json:"err,omitempty"`
+	Time       time.Time   `json:"time"`
+}
+
+// jobState is the state of a job, as replayed from the log
+type jobState struct {
+	job        *Job
+	state      string
+	checkpoint *Checkpoint
+	err        string
+}
+
+// JobQueue is a durable queue of paginated jobs, for crawls that outlive the process. Each job
+// added, leased, each page completed and each job done is appended to a log file, which is replayed
+// when the queue is opened, so that pending jobs resume from their last completed page after
+// a restart. Pages are delivered at least once: a page is completed only after the handler
+// returns, so the page in-flight during a crash is delivered again, with the same index.
+type JobQueue struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	workers int
+	jobs    map[string]*jobState
+	order   []string
+}
+
+// JobQueueOption job queue options struct
+type JobQueueOption func(*JobQueue)
+
+// WithWorkers (default: 1) sets the number of jobs run concurrently. The jobs share
+// the rate limit budget of the client, so more workers mostly help with pools.
+func WithWorkers(n int) JobQueueOption {
+	return func(jq *JobQueue) {
+		if n > 0 {
+			jq.workers = n
+		}
+	}
+}
+
+// OpenJobQueue opens the job queue of the log file at path, creating it if needed, and replays it.
+// Jobs that were leased when the process stopped are pending again.
+func OpenJobQueue(path string, options ...JobQueueOption) (*JobQueue, error) {
+	jq := &JobQueue{
+		path:    path,
+		workers: 1,
+		jobs:    make(map[string]*jobState),
+	}
+
+	for _, o := range options {
+		o(jq)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	jq.file = file
+
+	if err := jq.replay(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return jq, nil
+}
+
+// replay rebuilds the state of the jobs from the log. A partial record at the end
+// of the log, from a crash while appending, is truncated.
+func (jq *JobQueue) replay() error {
+	reader := bufio.NewReader(jq.file)
+
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		record := new(jobRecord)
+		if err := json.Unmarshal(line, record); err != nil {
+			return fmt.Errorf("twitter: corrupted job queue log at offset %d: %v", offset, err)
+		}
+		jq.apply(record)
+		offset += int64(len(line))
+	}
+
+	// leases don't survive the process
+	for _, s := range jq.jobs {
+		if s.state == JobLeased {
+			s.state = JobPending
+		}
+	}
+
+	if err := jq.file.Truncate(offset); err != nil {
+		return err
+	}
+	_, err := jq.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+// apply updates the state of the jobs with the record.
+func (jq *JobQueue) apply(record *jobRecord) {
+	s, ok := jq.jobs[record.ID]
+	if !ok && record.Op != "add" {
+		return
+	}
+
+	switch record.Op {
+	case "add":
+		if ok {
+			// a failed job added again is pending, from its last completed page
+			if s.state == JobFailed {
+				s.state, s.err = JobPending, ""
+			}
+			return
+		}
+		jq.jobs[record.ID] = &jobState{job: record.Job, state: JobPending}
+		jq.order = append(jq.order, record.ID)
+	case "lease":
+		s.state = JobLeased
+	case "page":
+		s.checkpoint = record.Checkpoint
+	case "done":
+		s.state, s.checkpoint = JobDone, record.Checkpoint
+	case "fail":
+		s.state, s.err = JobFailed, record.Err
+	}
+}
+
+// append writes the record to the log, syncs it to disk and applies it. The caller holds the lock.
+func (jq *JobQueue) append(record *jobRecord) error {
+	record.Time = time.Now()
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if _, err := jq.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if err := jq.file.Sync(); err != nil {
+		return err
+	}
+
+	jq.apply(record)
+	return nil
+}
+
+// Add adds the jobs to the queue. Adding a job with the ID of a pending or done job is a no-op,
+// so jobs can be added again on every start; adding a failed job again retries it.
+func (jq *JobQueue) Add(jobs ...*Job) error {
+	jq.mu.Lock()
+	defer jq.mu.Unlock()
+
+	for _, job := range jobs {
+		if _, ok := jobKinds[job.Kind]; !ok {
+			return ErrUnknownJobKind
+		}
+
+		j := *job
+		if j.ID == "" {
+			j.ID = j.Kind + ":" + j.UserID
+		}
+
+		if s, ok := jq.jobs[j.ID]; ok && s.state != JobFailed {
+			continue
+		}
+
+		if err := jq.append(&jobRecord{Op: "add", ID: j.ID, Job: &j}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Jobs returns the status of each job, in the order they were added.
+func (jq *JobQueue) Jobs() []*JobStatus {
+	jq.mu.Lock()
+	defer jq.mu.Unlock()
+
+	statuses := make([]*JobStatus, 0, len(jq.order))
+	for _, id := range jq.order {
+		s := jq.jobs[id]
+		status := &JobStatus{Job: s.job, State: s.state, Err: s.err}
+		if s.checkpoint != nil {
+			status.Pages, status.Results = s.checkpoint.Pages, s.checkpoint.Results
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+// Run runs the pending jobs with api, passing their pages to handler, until there are no more
+// pending jobs, ctx is done or the handler returns an error, which is returned. The queue options
+// apply to the paginator of each job. Jobs that fail with an API error that isn't retryable
+// (see DefaultRetryable) are marked as failed and the rest of the jobs continue. Any other error,
+// like a rate limit or server error left after the retries of the queue options, or a network error,
+// stops the run and is returned, while the job stays pending, from its last completed page.
+func (jq *JobQueue) Run(ctx context.Context, api *Twitter, handler JobHandler, options ...QueueOption) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var once sync.Once
+	var runErr error
+	stop := func(err error) {
+		once.Do(func() {
+			runErr = err
+			cancel()
+		})
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < jq.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				s, err := jq.lease()
+				if err != nil {
+					stop(err)
+					return
+				}
+				if s == nil {
+					return
+				}
+				if err := jq.process(ctx, api, s, handler, options); err != nil {
+					stop(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if runErr != nil {
+		return runErr
+	}
+	return ctx.Err()
+}
+
+// lease leases the next pending job, or returns nil if there are none.
+func (jq *JobQueue) lease() (*jobState, error) {
+	jq.mu.Lock()
+	defer jq.mu.Unlock()
+
+	for _, id := range jq.order {
+		if s := jq.jobs[id]; s.state == JobPending {
+			if err := jq.append(&jobRecord{Op: "lease", ID: id}); err != nil {
+				return nil, err
+			}
+			return s, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// release makes the leased job pending again, from its last completed page.
+func (jq *JobQueue) release(s *jobState) {
+	jq.mu.Lock()
+	defer jq.mu.Unlock()
+
+	if s.state == JobLeased {
+		s.state = JobPending
+	}
+}
+
+// process runs the leased job, from its last completed page. It returns the errors
+// that stop the queue, API errors that aren't retryable fail the job instead.
+func (jq *JobQueue) process(ctx context.Context, api *Twitter, s *jobState, handler JobHandler, options []QueueOption) error {
+	job := s.job
+
+	// the paginator resumes from the last completed page and persists its progress in memory,
+	// the progress is appended to the log only once the handler is done with each page
+	store := NewMemoryCheckpointStore()
+	if s.checkpoint != nil {
+		store.Save(job.ID, s.checkpoint)
+	}
+	options = append(options[:len(options):len(options)], WithCheckpoint(store, job.ID))
+
+	params := url.Values{}
+	for k, v := range job.Params {
+		params[k] = v
+	}
+	pages := jobKinds[job.Kind](api, job.UserID, params, options...)
+
+	for pages.HasMore() {
+		index := 0
+		if s.checkpoint != nil {
+			index = s.checkpoint.Pages
+		}
+
+		data, err := pages.Next(ctx)
+		if err == ErrNoMorePages {
+			break
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				jq.release(s)
+				return ctx.Err()
+			}
+			// transient errors leave the job pending for the next run
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || DefaultRetryable(apiErr.StatusCode) {
+				jq.release(s)
+				return err
+			}
+			return jq.commit(&jobRecord{Op: "fail", ID: job.ID, Err: err.Error()})
+		}
+
+		if err := handler(ctx, &JobPage{Job: job, Index: index, Data: data}); err != nil {
+			jq.release(s)
+			return err
+		}
+
+		checkpoint, err := store.Load(job.ID)
+		if err == ErrCheckpointNotFound {
+			break
+		}
+		if err := jq.commit(&jobRecord{Op: "page", ID: job.ID, Checkpoint: checkpoint}); err != nil {
+			return err
+		}
+	}
+
+	return jq.commit(&jobRecord{Op: "done", ID: job.ID, Checkpoint: pages.checkpoint})
+}
+
+// commit appends the record to the log.
+func (jq *JobQueue) commit(record *jobRecord) error {
+	jq.mu.Lock()
+	defer jq.mu.Unlock()
+
+	return jq.append(record)
+}
+
+// Compact rewrites the log with the current state of the jobs only, dropping the records of
+// completed pages and leases. The log is replaced atomically. It should not be called during Run.
+func (jq *JobQueue) Compact() error {
+	jq.mu.Lock()
+	defer jq.mu.Unlock()
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	now := time.Now()
+
+	for _, id := range jq.order {
+		s := jq.jobs[id]
+		records := []*jobRecord{{Op: "add", ID: id, Job: s.job, Time: now}}
+		if s.checkpoint != nil && s.state != JobDone {
+			records = append(records, &jobRecord{Op: "page", ID: id, Checkpoint: s.checkpoint, Time: now})
+		}
+		switch s.state {
+		case JobDone:
+			records = append(records, &jobRecord{Op: "done", ID: id, Checkpoint: s.checkpoint, Time: now})
+		case JobFailed:
+			records = append(records, &jobRecord{Op: "fail", ID: id, Err: s.err, Time: now})
+		}
+
+		for _, r := range records {
+			if err := encoder.Encode(r); err != nil {
+				return err
+			}
+		}
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(jq.path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), jq.path); err != nil {
+		return err
+	}
+
+	// continue appending to the compacted log
+	file, err := os.OpenFile(jq.path, os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	jq.file.Close()
+	jq.file = file
+
+	return nil
+}
+
+// Close closes the log file.
+func (jq *JobQueue) Close() error {
+	jq.mu.Lock()
+	defer jq.mu.Unlock()
+
+	return jq.file.Close()
+}
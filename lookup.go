@@ -0,0 +1,216 @@
+package twitter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// UsersLookup holds the merged results of LookupUsers and LookupUsersByUserName.
+type UsersLookup struct {
+	// Users are the users found, in the order they were returned
+	Users []*User
+	// Includes are the merged includes of all requests
+	Includes *Includes
+	// Errors holds the partial error of each id, or username, not found
+	Errors map[string]*Problem
+}
+
+// TweetsLookup holds the merged results of LookupTweets.
+type TweetsLookup struct {
+	// Tweets are the tweets found, in the order they were returned
+	Tweets []*Tweet
+	// Includes are the merged includes of all requests
+	Includes *Includes
+	// Errors holds the partial error of each id not found
+	Errors map[string]*Problem
+}
+
+// LookupUsers returns the users specified by any number of ids. The ids are deduplicated and sent in
+// chunks of 100 to the `/2/users` endpoint, through a single queue, merging the results and includes.
+// The ids not found are reported in the lookup's Errors. If a request fails, the lookup holds
+// the results so far, along with the error.
+func (api *Twitter) LookupUsers(ids []string, v url.Values, options ...QueueOption) (*UsersLookup, error) {
+	lookup := &UsersLookup{Includes: &Includes{}, Errors: make(map[string]*Problem)}
+	includes := newIncludesMerger(lookup.Includes)
+	err := api.lookup(fmt.Sprintf("%s/users", api.baseURL), "/users", "ids", ids, false, v, options, func(results *Data) error {
+		var users []*User
+		if err := decode(results.Data, &users); err != nil {
+			return err
+		}
+		lookup.Users = append(lookup.Users, users...)
+		includes.merge(results.Includes)
+		for _, p := range results.Errors {
+			lookup.Errors[p.Value] = p
+		}
+		return nil
+	})
+	return lookup, err
+}
+
+// LookupUsersByUserName is like LookupUsers, but the users are specified by any number of usernames,
+// sent in chunks of 100 to the `/2/users/by` endpoint. Usernames are deduplicated case-insensitively.
+func (api *Twitter) LookupUsersByUserName(usernames []string, v url.Values, options ...QueueOption) (*UsersLookup, error) {
+	lookup := &UsersLookup{Includes: &Includes{}, Errors: make(map[string]*Problem)}
+	includes := newIncludesMerger(lookup.Includes)
+	err := api.lookup(fmt.Sprintf("%s/users/by", api.baseURL), "/users/by", "usernames", usernames, true, v, options, func(results *Data) error {
+		var users []*User
+		if err := decode(results.Data, &users); err != nil {
+			return err
+		}
+		lookup.Users = append(lookup.Users, users...)
+		includes.merge(results.Includes)
+		for _, p := range results.Errors {
+			lookup.Errors[p.Value] = p
+		}
+		return nil
+	})
+	return lookup, err
+}
+
+// LookupTweets returns the tweets specified by any number of ids. The ids are deduplicated and sent in
+// chunks of 100 to the `/2/tweets` endpoint, through a single queue, merging the results and includes.
+// The ids not found are reported in the lookup's Errors. If a request fails, the lookup holds
+// the results so far, along with the error.
+func (api *Twitter) LookupTweets(ids []string, v url.Values, options ...QueueOption) (*TweetsLookup, error) {
+	lookup := &TweetsLookup{Includes: &Includes{}, Errors: make(map[string]*Problem)}
+	includes := newIncludesMerger(lookup.Includes)
+	err := api.lookup(fmt.Sprintf("%s/tweets", api.baseURL), "/tweets", "ids", ids, false, v, options, func(results *Data) error {
+		var tweets []*Tweet
+		if err := decode(results.Data, &tweets); err != nil {
+			return err
+		}
+		lookup.Tweets = append(lookup.Tweets, tweets...)
+		includes.merge(results.Includes)
+		for _, p := range results.Errors {
+			lookup.Errors[p.Value] = p
+		}
+		return nil
+	})
+	return lookup, err
+}
+
+// lookup sends the deduplicated values in chunks of 100, as the param parameter of the endpoint,
+// passing the results of each chunk to merge. It stops at the first error of a request or of merge.
+func (api *Twitter) lookup(urlStr, endpoint, param string, values []string, fold bool, v url.Values, options []QueueOption, merge func(*Data) error) error {
+	queue := NewQueue(15*time.Minute/300, 15*time.Minute, true, nil, nil, options...)
+	defer queue.cancel()
+	values = dedupe(values, fold)
+
+	for start := 0; start < len(values); start += maxBatchSize {
+		end := start + maxBatchSize
+		if end > len(values) {
+			end = len(values)
+		}
+
+		// create the request object
+		request, err := NewRquest("GET", urlStr, v, nil)
+		if err != nil {
+			return err
+		}
+		request.Endpoint = endpoint
+		request.UpdateURLValues(url.Values{param: {strings.Join(values[start:end], ",")}})
+
+		// use the cached response, if any, or send the request on twitter api
+		cached := api.loadCache(request)
+		if !cached {
+			if err := queue.dispatch(api, request); err != nil {
+				return err
+			}
+			api.storeCache(request)
+		}
+
+		if err := merge(&request.Results); err != nil {
+			return err
+		}
+
+		// throttle requests to avoid rate-limit errors
+		if !cached && end < len(values) && !queue.wait(queue.next(request.RateLimit)) {
+			return queue.ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// dedupe returns the values without duplicates or empty values, in their original order.
+// If fold is set, values are compared case-insensitively.
+func dedupe(values []string, fold bool) []string {
+	seen := make(map[string]bool, len(values))
+	unique := make([]string, 0, len(values))
+
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		key := v
+		if fold {
+			key = strings.ToLower(v)
+		}
+		if v == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, v)
+	}
+
+	return unique
+}
+
+// decode converts the data of a response to v.
+func decode(data *interface{}, v interface{}) error {
+	if data == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// includesMerger appends includes to a lookup's Includes, skipping duplicates. It keeps the ids
+// seen for each kind of include, for the whole lookup.
+type includesMerger struct {
+	dst    *Includes
+	tweets map[string]bool
+	users  map[string]bool
+	media  map[string]bool
+}
+
+// newIncludesMerger returns an includesMerger appending to dst.
+func newIncludesMerger(dst *Includes) *includesMerger {
+	return &includesMerger{
+		dst:    dst,
+		tweets: make(map[string]bool),
+		users:  make(map[string]bool),
+		media:  make(map[string]bool),
+	}
+}
+
+// merge appends the includes of src, skipping those already merged.
+func (m *includesMerger) merge(src *Includes) {
+	if src == nil {
+		return
+	}
+
+	for _, t := range src.Tweets {
+		if !m.tweets[t.ID] {
+			m.tweets[t.ID] = true
+			m.dst.Tweets = append(m.dst.Tweets, t)
+		}
+	}
+	for _, u := range src.Users {
+		if !m.users[u.ID] {
+			m.users[u.ID] = true
+			m.dst.Users = append(m.dst.Users, u)
+		}
+	}
+	for _, media := range src.Media {
+		if !m.media[media.MediaKey] {
+			m.media[media.MediaKey] = true
+			m.dst.Media = append(m.dst.Media, media)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package twitter
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Handler sends a request to Twitter API and returns its response.
+type Handler func(req *Request) (*http.Response, error)
+
+// Middleware wraps a Handler, to observe or change each request and its response.
+// The request's Endpoint holds the endpoint template, e.g. `/users/:id/followers`.
+// Middlewares must not consume the response body, unless they replace it.
+type Middleware func(next Handler) Handler
+
+// WithMiddleware adds middlewares to the client, wrapping every request sent to Twitter API,
+// including the connection of streams. Middlewares are called in the order they are added.
+func WithMiddleware(middlewares ...Middleware) ClientOption {
+	return func(api *Twitter) {
+		api.middlewares = append(api.middlewares, middlewares...)
+	}
+}
+
+// chain wraps h with the client's middlewares.
+func (api *Twitter) chain(h Handler) Handler {
+	for i := len(api.middlewares) - 1; i >= 0; i-- {
+		h = api.middlewares[i](h)
+	}
+	return h
+}
+
+// redacted replaces the values of credentials
+const redacted = "REDACTED"
+
+// sensitiveParams are the query parameters that carry credentials
+var sensitiveParams = []string{
+	"access_token", "refresh_token", "client_secret", "code", "code_verifier",
+	"oauth_token", "oauth_verifier", "oauth_signature", "oauth_consumer_key",
+}
+
+// sensitiveHeaders are the headers that carry credentials
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Csrf-Token"}
+
+// RedactURL returns u as a string, with the values of credential parameters redacted.
+func RedactURL(u *url.URL) string {
+	c := *u
+	c.User = nil
+
+	query := c.Query()
+	for _, p := range sensitiveParams {
+		if _, ok := query[p]; ok {
+			query.Set(p, redacted)
+		}
+	}
+	c.RawQuery = query.Encode()
+
+	return c.String()
+}
+
+// RedactHeader returns a copy of h, with the values of credential headers redacted.
+// The authorization scheme, e.g. `Bearer`, is kept.
+func RedactHeader(h http.Header) http.Header {
+	c := h.Clone()
+	for _, k := range sensitiveHeaders {
+		values := c.Values(k)
+		for i, v := range values {
+			if scheme := strings.SplitN(v, " ", 2); k == "Authorization" && len(scheme) == 2 {
+				values[i] = scheme[0] + " " + redacted
+				continue
+			}
+			values[i] = redacted
+		}
+	}
+	return c
+}
+
+// LoggingMiddleware logs each request and its outcome to logger, with
+// credentials redacted from the url and the headers of the request.
+func LoggingMiddleware(logger *log.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(req *Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next(req)
+			d := time.Since(start)
+
+			status := "-"
+			if resp != nil {
+				status = resp.Status
+			}
+
+			if err != nil {
+				logger.Printf("twitter: %s %s endpoint=%s headers=%v duration=%s error=%v",
+					req.Req.Method, RedactURL(req.Req.URL), req.Endpoint, RedactHeader(req.Req.Header), d, err)
+				return resp, err
+			}
+
+			logger.Printf("twitter: %s %s endpoint=%s headers=%v duration=%s status=%s",
+				req.Req.Method, RedactURL(req.Req.URL), req.Endpoint, RedactHeader(req.Req.Header), d, status)
+			return resp, err
+		}
+	}
+}
+
+// TimingMiddleware calls observe with the duration of each request, until the response headers
+// are received, e.g. to export latency metrics or tracing spans. resp is nil if err is set.
+func TimingMiddleware(observe func(req *Request, resp *http.Response, err error, d time.Duration)) Middleware {
+	return func(next Handler) Handler {
+		return func(req *Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next(req)
+			observe(req, resp, err, time.Since(start))
+			return resp, err
+		}
+	}
+}
+
+// HeaderMiddleware sets the headers on each request, e.g. for tracing.
+func HeaderMiddleware(header http.Header) Middleware {
+	return func(next Handler) Handler {
+		return func(req *Request) (*http.Response, error) {
+			for k, v := range header {
+				req.Req.Header[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+			}
+			return next(req)
+		}
+	}
+}
+
+// CaptureMiddleware calls capture with the body of each response, before the response is
+// parsed, e.g. to archive raw payloads. The body is read in full, so streams are skipped.
+func CaptureMiddleware(capture func(req *Request, resp *http.Response, body []byte)) Middleware {
+	return func(next Handler) Handler {
+		return func(req *Request) (*http.Response, error) {
+			resp, err := next(req)
+			if err != nil || resp.Body == nil || isStream(req) {
+				return resp, err
+			}
+
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+
+			// replace the consumed body
+			resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+			capture(req, resp, body)
+			return resp, nil
+		}
+	}
+}
+
+// isStream reports whether req is the connection of a stream.
+func isStream(req *Request) bool {
+	return strings.HasSuffix(req.Endpoint, "/stream")
+}
@@ -0,0 +1,200 @@
+package twitter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+// OAuth1Config holds the configuration of the OAuth 1.0a three-legged (or PIN-based) sign in flow.
+type OAuth1Config struct {
+	// ConsumerKey is the API Key of the App
+	ConsumerKey string
+	// ConsumerSecret is the API Key Secret of the App
+	ConsumerSecret string
+	// CallbackURL is the loopback callback url registered with the App, e.g.
+	// `http://127.0.0.1:8080/callback`. A local listener is started on its host to
+	// capture the verifier. Use port 0 to pick a free port. If empty, the PIN-based
+	// (oob) flow is used instead.
+	CallbackURL string
+	// OnAuthorizeURL is called with the url the user must visit to authorize the App.
+	// By default the url is printed to stdout.
+	OnAuthorizeURL func(authorizeURL string) error
+	// OnPIN returns the PIN the user received after authorizing the App, on the
+	// PIN-based flow. By default the PIN is read from stdin.
+	OnPIN func() (string, error)
+}
+
+// AccessToken holds the OAuth 1.0a user access token, as returned by the sign in flow.
+type AccessToken struct {
+	Token      string
+	Secret     string
+	UserID     string
+	ScreenName string
+}
+
+// ErrAuthorizationDenied is returned when the user denies to authorize the App.
+var ErrAuthorizationDenied = errors.New("oauth: authorization denied")
+
+// NewTwitterWithSignIn runs the OAuth 1.0a three-legged flow (request token, authorization, verifier
+// exchange) and returns a new Twitter API v2 Client using OAuth 1.0a User Context, along with the
+// access token, that can be used with NewTwitterWithContext afterwards. If a TokenStore is set with
+// WithTokenStore, the stored token skips the flow and the new token is saved.
+// Official Documentation: https://developer.twitter.com/en/docs/authentication/oauth-1-0a/obtaining-user-access-tokens
+// PIN-Based: https://developer.twitter.com/en/docs/authentication/oauth-1-0a/pin-based-oauth
+func NewTwitterWithSignIn(ctx context.Context, config *OAuth1Config, options ...ClientOption) (*Twitter, *AccessToken, error) {
+	base := newTwitter(authUser, options...)
+
+	// use the stored token, if any
+	stored, err := base.loadToken()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var token *AccessToken
+	if stored != nil && stored.TokenSecret != "" {
+		token = &AccessToken{
+			Token:      stored.AccessToken,
+			Secret:     stored.TokenSecret,
+			UserID:     stored.UserID,
+			ScreenName: stored.ScreenName,
+		}
+	} else {
+		if token, err = config.signIn(ctx, base); err != nil {
+			return nil, nil, err
+		}
+
+		err = base.saveToken(&Token{
+			AccessToken: token.Token,
+			TokenSecret: token.Secret,
+			UserID:      token.UserID,
+			ScreenName:  token.ScreenName,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	api, err := NewTwitterWithContext(config.ConsumerKey, config.ConsumerSecret, token.Token, token.Secret, options...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return api, token, nil
+}
+
+// signIn runs the sign in flow, using api's authentication endpoints.
+func (c *OAuth1Config) signIn(ctx context.Context, api *Twitter) (*AccessToken, error) {
+	consumer := api.newConsumer(c.ConsumerKey, c.ConsumerSecret)
+
+	var verifier func() (string, error)
+	setToken := func(string) {}
+	callbackURL := "oob"
+
+	if c.CallbackURL != "" {
+		u, err := url.Parse(c.CallbackURL)
+		if err != nil {
+			return nil, err
+		}
+
+		// the request token is unknown until the listener starts
+		var mu sync.Mutex
+		var requestToken string
+
+		callback, err := listenCallback(u, func(q url.Values) (string, error) {
+			if q.Get("denied") != "" {
+				return "", ErrAuthorizationDenied
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if requestToken == "" || q.Get("oauth_token") != requestToken {
+				return "", ErrStateMismatch
+			}
+			return q.Get("oauth_verifier"), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer callback.Close()
+
+		// the listener may have picked a free port
+		callbackURL = callback.url.String()
+		verifier = func() (string, error) {
+			return callback.wait(ctx)
+		}
+		setToken = func(token string) {
+			mu.Lock()
+			defer mu.Unlock()
+			requestToken = token
+		}
+	} else {
+		verifier = func() (string, error) {
+			return c.pin(ctx)
+		}
+	}
+
+	// get the request token and the authorization url
+	rtoken, authorizeURL, err := consumer.GetRequestTokenAndUrl(callbackURL)
+	if err != nil {
+		return nil, err
+	}
+	setToken(rtoken.Token)
+
+	onAuthorizeURL := c.OnAuthorizeURL
+	if onAuthorizeURL == nil {
+		onAuthorizeURL = func(authorizeURL string) error {
+			_, err := fmt.Printf("Visit the URL to authorize the app: %v\n", authorizeURL)
+			return err
+		}
+	}
+	if err := onAuthorizeURL(authorizeURL); err != nil {
+		return nil, err
+	}
+
+	code, err := verifier()
+	if err != nil {
+		return nil, err
+	}
+
+	// exchange the request token and the verifier for the access token
+	atoken, err := consumer.AuthorizeToken(rtoken, code)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccessToken{
+		Token:      atoken.Token,
+		Secret:     atoken.Secret,
+		UserID:     atoken.AdditionalData["user_id"],
+		ScreenName: atoken.AdditionalData["screen_name"],
+	}, nil
+}
+
+// pin returns the PIN of the PIN-based flow, or ctx's error once it is done.
+func (c *OAuth1Config) pin(ctx context.Context) (string, error) {
+	onPIN := c.OnPIN
+	if onPIN == nil {
+		onPIN = func() (string, error) {
+			var pin string
+			fmt.Print("Enter the PIN: ")
+			_, err := fmt.Scan(&pin)
+			return pin, err
+		}
+	}
+
+	result := make(chan callbackResult, 1)
+	go func() {
+		pin, err := onPIN()
+		result <- callbackResult{pin, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case r := <-result:
+		return r.value, r.err
+	}
+}
@@ -0,0 +1,118 @@
+package twitter
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+)
+
+// ErrNoMorePages is returned by Paginator.Next once there are no more pages.
+var ErrNoMorePages = errors.New("twitter: no more pages")
+
+// Paginator fetches the pages of a paginated endpoint one at a time, as an alternative
+// to the results channel returned by the Get methods. Each page is fetched synchronously by Next,
+// so the caller controls the pacing and can stop at any time without leaking goroutines.
+// The rate limit budget and the retry policy of the queue options are still respected.
+// A Paginator is not safe for concurrent use.
+type Paginator struct {
+	api        *Twitter
+	queue      *Queue
+	req        *Request
+	tokenParam string
+	nextToken  string
+	checkpoint *Checkpoint
+	started    bool
+	closed     bool
+}
+
+// newPaginator returns a new Paginator for the request, following the `next_token` of each
+// page with tokenParam. The queue options apply to each page, except WithContext.
+func newPaginator(api *Twitter, req *Request, tokenParam string, rate, delay time.Duration, options ...QueueOption) *Paginator {
+	return &Paginator{
+		api:        api,
+		queue:      NewQueue(rate, delay, true, nil, nil, options...),
+		req:        req,
+		tokenParam: tokenParam,
+	}
+}
+
+// Next fetches the next page, bounded by ctx. It returns ErrNoMorePages once there are no
+// more pages or the paginator is closed. On error, the same page is fetched by the next call.
+func (p *Paginator) Next(ctx context.Context) (*Data, error) {
+	if !p.HasMore() {
+		return nil, ErrNoMorePages
+	}
+
+	// resume from the queue's checkpoint, if any
+	if p.checkpoint == nil {
+		checkpoint, err := p.queue.resume(p.req, p.tokenParam)
+		if err != nil {
+			return nil, err
+		}
+
+		// there are no more pages if the checkpoint already reached the caps
+		if p.queue.reached(checkpoint) {
+			if err := p.queue.checkpoint(checkpoint, p.tokenParam, ""); err != nil {
+				return nil, err
+			}
+			p.closed = true
+			return nil, ErrNoMorePages
+		}
+		p.checkpoint = checkpoint
+	}
+
+	if p.nextToken != "" {
+		p.req.UpdateURLValues(url.Values{p.tokenParam: {p.nextToken}})
+	}
+	p.queue.prepare(p.req, p.checkpoint)
+	p.req.ResetResults()
+	defer p.queue.bind(ctx)()
+
+	// use the cached response, if any, or send the request on twitter api
+	if !p.api.loadCache(p.req) {
+		if err := p.queue.dispatch(p.api, p.req); err != nil {
+			return nil, err
+		}
+		p.api.storeCache(p.req)
+	}
+
+	results := p.req.Results
+	next := ""
+	if results.Meta != nil {
+		next = results.Meta.NextToken
+	}
+
+	// persist the progress before returning the results, so that they are
+	// never returned again on resume, otherwise the page is fetched again
+	checkpoint := *p.checkpoint
+	if p.queue.capped(&results, &checkpoint) {
+		next = ""
+	}
+	if err := p.queue.checkpoint(&checkpoint, p.tokenParam, next); err != nil {
+		return nil, err
+	}
+
+	p.checkpoint = &checkpoint
+	p.started = true
+	p.nextToken = next
+
+	return &results, nil
+}
+
+// HasMore reports whether there are more pages to fetch, before
+// one of the caps of the queue options is reached.
+func (p *Paginator) HasMore() bool {
+	return !p.closed && (!p.started || p.nextToken != "")
+}
+
+// NextToken returns the token of the next page, or an empty string if there are no more pages.
+func (p *Paginator) NextToken() string {
+	return p.nextToken
+}
+
+// Close stops the pagination, Next returns ErrNoMorePages from then on.
+func (p *Paginator) Close() error {
+	p.closed = true
+	return nil
+}
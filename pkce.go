@@ -0,0 +1,357 @@
+package twitter
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// OAuth 2.0 Authorization Code with PKCE endpoints
+const (
+	OAuth2AuthorizeURL = "https://twitter.com/i/oauth2/authorize"
+	OAuth2TokenURL     = "https://api.twitter.com/2/oauth2/token"
+)
+
+// OAuth 2.0 scopes, for a detailed description of each scope refer to
+// https://developer.twitter.com/en/docs/authentication/oauth-2-0/authorization-code
+const (
+	ScopeTweetRead          = "tweet.read"
+	ScopeTweetWrite         = "tweet.write"
+	ScopeTweetModerateWrite = "tweet.moderate.write"
+	ScopeUsersRead          = "users.read"
+	ScopeFollowsRead        = "follows.read"
+	ScopeFollowsWrite       = "follows.write"
+	ScopeOfflineAccess      = "offline.access"
+	ScopeSpaceRead          = "space.read"
+	ScopeMuteRead           = "mute.read"
+	ScopeMuteWrite          = "mute.write"
+	ScopeLikeRead           = "like.read"
+	ScopeLikeWrite          = "like.write"
+	ScopeListRead           = "list.read"
+	ScopeListWrite          = "list.write"
+	ScopeBlockRead          = "block.read"
+	ScopeBlockWrite         = "block.write"
+	ScopeBookmarkRead       = "bookmark.read"
+	ScopeBookmarkWrite      = "bookmark.write"
+)
+
+// ErrStateMismatch is returned when the state of the authorization callback
+// doesn't match the state of the authorization request.
+var ErrStateMismatch = errors.New("oauth2: state mismatch")
+
+// PKCEConfig holds the configuration of the OAuth 2.0 Authorization Code with PKCE flow.
+type PKCEConfig struct {
+	// ClientID is the OAuth 2.0 Client ID of the App
+	ClientID string
+	// ClientSecret is the OAuth 2.0 Client Secret, only for confidential clients
+	ClientSecret string
+	// RedirectURL is the loopback callback url registered with the App, e.g.
+	// `http://127.0.0.1:8080/callback`. A local listener is started on its
+	// host to capture the authorization code. Use port 0 to pick a free port.
+	RedirectURL string
+	// Scopes (default: tweet.read, users.read, offline.access) requested by the App
+	Scopes []string
+	// AuthURL (default: OAuth2AuthorizeURL) is the authorization url
+	AuthURL string
+	// TokenURL (default: OAuth2TokenURL, relative to WithOAuthBaseURL) is the token url
+	TokenURL string
+	// Token, if set, skips the authorization and uses the token instead.
+	// Otherwise the token of the client's TokenStore is used, if any.
+	Token *oauth2.Token
+	// OnAuthCodeURL is called with the url the user must visit to authorize the App.
+	// By default the url is printed to stdout.
+	OnAuthCodeURL func(authURL string) error
+	// OnRefresh is called each time the token is refreshed. Twitter rotates the
+	// refresh token on each refresh, so the new token must be used from then on.
+	// Refreshed tokens are saved to the client's TokenStore automatically; if saving
+	// fails, the request fails with the store's error and the save is retried.
+	OnRefresh func(token *oauth2.Token)
+}
+
+// NewTwitterWithPKCE returns a new Twitter API v2 Client using OAuth 2.0 Authorization Code with PKCE
+// based authentication. This method is useful when you need to make API requests on behalf of a Twitter
+// account, on endpoints that only accept OAuth 2.0 User Context, such as bookmarks.
+// The user is asked to visit the authorization url and the code is captured by a local listener on
+// the redirect url. The token is refreshed automatically when the `offline.access` scope is granted.
+// Official Documentation: https://developer.twitter.com/en/docs/authentication/oauth-2-0/authorization-code
+func NewTwitterWithPKCE(ctx context.Context, config *PKCEConfig, options ...ClientOption) (*Twitter, error) {
+	// init new Twitter client
+	api := newTwitter(authUser, options...)
+
+	oauthConfig := config.oauth2Config(api.oauthURL + "/2/oauth2/token")
+
+	// Use the custom HTTP client when requesting a token.
+	httpClient := api.newHTTPClient()
+	if api.timeout > 0 {
+		httpClient.Timeout = api.timeout
+	} else if httpClient.Timeout == 0 {
+		httpClient.Timeout = tokenTimeout
+	}
+	clientCtx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)
+
+	// use the stored token, if any
+	token := config.Token
+	if token == nil {
+		stored, err := api.loadToken()
+		if err != nil {
+			return nil, err
+		}
+		if stored != nil {
+			token = stored.oauth2Token()
+		}
+	}
+
+	if token == nil {
+		t, err := config.authorize(context.WithValue(ctx, oauth2.HTTPClient, httpClient), oauthConfig)
+		if err != nil {
+			return nil, err
+		}
+		token = t
+
+		if err := api.saveToken(newOAuth2Token(token)); err != nil {
+			return nil, err
+		}
+	}
+
+	// the token source refreshes the token (and rotates the refresh token) once expired,
+	// persisting the refreshed token before it is used
+	source := oauthConfig.TokenSource(clientCtx, token)
+	if config.OnRefresh != nil || api.tokenStore != nil {
+		source = &notifyTokenSource{source: source, token: token, notify: func(t *oauth2.Token) error {
+			if err := api.saveToken(newOAuth2Token(t)); err != nil {
+				return err
+			}
+			if config.OnRefresh != nil {
+				config.OnRefresh(t)
+			}
+			return nil
+		}}
+	}
+
+	// http.Client will automatically authorize Requests
+	api.client = oauth2.NewClient(clientCtx, source)
+	return api, nil
+}
+
+// oauth2Config returns the oauth2 config of the flow, using tokenURL unless set.
+func (c *PKCEConfig) oauth2Config(tokenURL string) *oauth2.Config {
+	authURL := c.AuthURL
+	if authURL == "" {
+		authURL = OAuth2AuthorizeURL
+	}
+
+	if c.TokenURL != "" {
+		tokenURL = c.TokenURL
+	}
+
+	scopes := c.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{ScopeTweetRead, ScopeUsersRead, ScopeOfflineAccess}
+	}
+
+	// public clients send their id in the request body, while confidential
+	// clients authenticate with basic authentication
+	authStyle := oauth2.AuthStyleInParams
+	if c.ClientSecret != "" {
+		authStyle = oauth2.AuthStyleInHeader
+	}
+
+	return &oauth2.Config{
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		RedirectURL:  c.RedirectURL,
+		Scopes:       scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   authURL,
+			TokenURL:  tokenURL,
+			AuthStyle: authStyle,
+		},
+	}
+}
+
+// authorize runs the authorization flow and exchanges the code for a token.
+func (c *PKCEConfig) authorize(ctx context.Context, config *oauth2.Config) (*oauth2.Token, error) {
+	redirectURL, err := url.Parse(config.RedirectURL)
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+
+	// start the loopback listener before sending the user to the consent page
+	callback, err := listenCallback(redirectURL, func(q url.Values) (string, error) {
+		if q.Get("state") != state {
+			return "", ErrStateMismatch
+		}
+		if e := q.Get("error"); e != "" {
+			return "", fmt.Errorf("oauth2: %s", e)
+		}
+		return q.Get("code"), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer callback.Close()
+
+	// the listener may have picked a free port
+	config.RedirectURL = callback.url.String()
+
+	// Redirect user to consent page to ask for permission
+	// for the scopes specified above.
+	authURL := config.AuthCodeURL(state,
+		oauth2.SetAuthURLParam("code_challenge", s256Challenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	)
+
+	onAuthCodeURL := c.OnAuthCodeURL
+	if onAuthCodeURL == nil {
+		onAuthCodeURL = func(authURL string) error {
+			_, err := fmt.Printf("Visit the URL for the auth dialog: %v\n", authURL)
+			return err
+		}
+	}
+	if err := onAuthCodeURL(authURL); err != nil {
+		return nil, err
+	}
+
+	code, err := callback.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Exchange will do the handshake to retrieve the initial access token.
+	return config.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", verifier))
+}
+
+// notifyTokenSource calls notify each time the underlying source returns a new token.
+// If notify fails, the token isn't used and notify is called again on the next request.
+type notifyTokenSource struct {
+	mu     sync.Mutex
+	source oauth2.TokenSource
+	token  *oauth2.Token
+	notify func(*oauth2.Token) error
+}
+
+// Token implements oauth2.TokenSource
+func (s *notifyTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.source.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	if s.token == nil || t.AccessToken != s.token.AccessToken {
+		if err := s.notify(t); err != nil {
+			return nil, err
+		}
+		s.token = t
+	}
+
+	return t, nil
+}
+
+// callbackListener is a local HTTP server, capturing the authorization callback.
+type callbackListener struct {
+	url    *url.URL
+	server *http.Server
+	result chan callbackResult
+}
+
+// callbackResult is the result of an authorization callback.
+type callbackResult struct {
+	value string
+	err   error
+}
+
+// listenCallback starts a callbackListener on the host of u. Each request on u's path
+// is parsed by parse, and the first result is delivered to wait.
+func listenCallback(u *url.URL, parse func(url.Values) (string, error)) (*callbackListener, error) {
+	listener, err := net.Listen("tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep the actual address, in case of port 0
+	cu := *u
+	cu.Host = listener.Addr().String()
+	if u.Hostname() == "localhost" {
+		cu.Host = net.JoinHostPort("localhost", fmt.Sprint(listener.Addr().(*net.TCPAddr).Port))
+	}
+
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+
+	c := &callbackListener{url: &cu, result: make(chan callbackResult, 1)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		value, err := parse(r.URL.Query())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Authorization failed: %s", err), http.StatusBadRequest)
+		} else {
+			fmt.Fprint(w, "Authorization completed, you may now close this window.")
+		}
+
+		// deliver the first result only
+		select {
+		case c.result <- callbackResult{value, err}:
+		default:
+		}
+	})
+
+	c.server = &http.Server{Handler: mux}
+	go c.server.Serve(listener)
+
+	return c, nil
+}
+
+// wait blocks until the callback is received or ctx is done.
+func (c *callbackListener) wait(ctx context.Context) (string, error) {
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case r := <-c.result:
+		return r.value, r.err
+	}
+}
+
+// Close stops the listener.
+func (c *callbackListener) Close() error {
+	return c.server.Close()
+}
+
+// randomString returns a url safe random string of n random bytes.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// s256Challenge returns the S256 code challenge of the verifier.
+func s256Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
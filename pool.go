@@ -0,0 +1,122 @@
+package twitter
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrPoolExhausted is returned when every client of a pool has been revoked.
+var ErrPoolExhausted = errors.New("twitter: no usable client left in the pool")
+
+// pool routes requests across several clients, each one with its own credentials
+// and rate limit budgets.
+type pool struct {
+	mu      sync.Mutex
+	members []*Twitter
+	revoked map[*Twitter]error
+}
+
+// NewPool returns a new Twitter API v2 Client that rotates across several clients, such as
+// multiple Apps of the same project. Each request is routed to the client with the most
+// remaining budget on the endpoint, while clients with exhausted budgets are skipped until
+// their window resets. Clients whose credentials are revoked are removed from the rotation,
+// failing over to the rest transparently. Use the returned client like any other client.
+func NewPool(clients ...*Twitter) (*Twitter, error) {
+	if len(clients) == 0 {
+		return nil, errors.New("twitter: a pool needs at least one client")
+	}
+
+	for _, c := range clients {
+		if c.pool != nil {
+			return nil, errors.New("twitter: a pool can't be a member of another pool")
+		}
+	}
+
+	return &Twitter{
+		client:   clients[0].client,
+		baseURL:  clients[0].baseURL,
+		v1URL:    clients[0].v1URL,
+		oauthURL: clients[0].oauthURL,
+		auth:     clients[0].auth,
+		flights:  newFlightGroup(),
+		clock:    clients[0].clock,
+		pool: &pool{
+			members: clients,
+			revoked: make(map[*Twitter]error),
+		},
+	}, nil
+}
+
+// reserve returns the client with the most remaining budget for the request's endpoint,
+// taking a request from its budget, or the duration to wait until one of them has budget.
+func (p *pool) reserve(req *Request, now time.Time) (*Twitter, time.Duration, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var best *Twitter
+	var most int
+	wait := time.Duration(-1)
+
+	for _, m := range p.members {
+		if p.revoked[m] != nil {
+			continue
+		}
+
+		remaining, d := m.bucket(req).peek(now)
+		if d > 0 {
+			if wait < 0 || d < wait {
+				wait = d
+			}
+			continue
+		}
+
+		if best == nil || remaining > most {
+			best, most = m, remaining
+		}
+	}
+
+	if best != nil {
+		return best, best.bucket(req).reserve(now), nil
+	}
+
+	if wait < 0 {
+		return nil, 0, ErrPoolExhausted
+	}
+
+	return nil, wait, nil
+}
+
+// pick returns the client with the most remaining budget for the request's endpoint at now,
+// without waiting for it, for requests that are not processed by a queue.
+func (p *pool) pick(req *Request, now time.Time) (*Twitter, error) {
+	client, _, err := p.reserve(req, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// every budget is exhausted, use the first usable client
+	if client == nil {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		for _, m := range p.members {
+			if p.revoked[m] == nil {
+				return m, nil
+			}
+		}
+		return nil, ErrPoolExhausted
+	}
+
+	return client, nil
+}
+
+// revoke removes the client from the rotation and reports whether
+// there are other usable clients left.
+func (p *pool) revoke(client *Twitter, err error) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.revoked[client] = err
+	return len(p.revoked) < len(p.members)
+}
@@ -1,21 +1,54 @@
 package twitter
 
 import (
+	"context"
+	"encoding/json"
+	"net/url"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 )
 
+// resetMargin is added to the rate limit reset time, to account for
+// clock differences between the client and Twitter API
+const resetMargin = time.Second
+
 // Queue struct holds information for each method, such as
-// @rate time.Duration specific for each endpoint on Twitter
+// @rate time.Duration specific for each endpoint on Twitter, used when no rate limit headers are returned
 // @delay time.Duration fallback for @rate, specific for each endpoint on Twitter
+// @throttle bool whether @rate was set explicitly and must always be respected
+// @retry *RetryPolicy the policy used to retry failed requests
+// @maxPages int the number of pages after which the pagination stops
+// @maxResults int the number of results after which the pagination stops
+// @until time.Time the creation time of tweets before which the pagination stops
+// @checkpoints CheckpointStore the store persisting the progress of the pagination under @checkpointKey
+// @clock Clock the clock used to pace, back off and retry requests
+// @ctx context.Context the context that bounds the lifetime of the queue, canceled by @cancel
+// @stats QueueStats the counters of the queue, guarded by @mu along with @resumed and @canceled
+// @resumed chan struct{} closed once a paused queue is resumed, nil if the queue is not paused
+// @hooks []func(*Queue) the functions called with the queue once it's created
 // @requestsChannel chan *Request the incoming (requests) channel
 // @responseChannel chan *Response the outgoing (response) channel
 type Queue struct {
+	mu              sync.Mutex
 	rate            time.Duration
 	delay           time.Duration
+	throttle        bool
 	auto            bool
+	retry           *RetryPolicy
+	maxPages        int
+	maxResults      int
+	until           time.Time
+	checkpoints     CheckpointStore
+	checkpointKey   string
+	clock           Clock
 	closeChannels   bool
+	ctx             context.Context
+	cancel          context.CancelFunc
+	canceled        bool
+	stats           QueueStats
+	resumed         chan struct{}
+	hooks           []func(*Queue)
 	requestsChannel chan *Request
 	responseChannel chan *Response
 }
@@ -24,15 +57,17 @@ type Queue struct {
 type QueueOption func(*Queue)
 
 // WithRate (default: according to endpoint) adjusts the the duration between each request to avoid
-// rate limits from Twitter API
+// rate limits from Twitter API. By default the queue is paced by the `x-rate-limit-*` headers and
+// the rate is only used when they are missing; setting it explicitly throttles every request.
 func WithRate(rate time.Duration) QueueOption {
 	return func(q *Queue) {
 		q.rate = rate
+		q.throttle = true
 	}
 }
 
 // WithDelay (default:15 minutes) adjusts the the duration between each errored requests
-// due to rate limit errors from Twitter API
+// due to rate limit errors from Twitter API, when the `x-rate-limit-reset` header is missing
 func WithDelay(delay time.Duration) QueueOption {
 	return func(q *Queue) {
 		q.delay = delay
@@ -47,76 +82,431 @@ func WithAuto(auto bool) QueueOption {
 	}
 }
 
+// WithMaxResults (default: unlimited) stops the pagination once n results are returned,
+// closing the channels. The `max_results` parameter of the last page is lowered accordingly,
+// and the results of the last page past n are dropped.
+func WithMaxResults(n int) QueueOption {
+	return func(q *Queue) {
+		q.maxResults = n
+	}
+}
+
+// WithContext (default: context.Background()) binds the queue to ctx. Once ctx is done
+// the in-flight request is canceled, pagination stops and the results channel is closed.
+func WithContext(ctx context.Context) QueueOption {
+	return func(q *Queue) {
+		q.ctx = ctx
+	}
+}
+
 // NewQueue creates a new queue
 func NewQueue(rate, delay time.Duration, auto bool, in chan *Request, out chan *Response, options ...QueueOption) *Queue {
-	queue := &Queue{rate, delay, auto, true, in, out}
+	queue := &Queue{
+		rate:            rate,
+		delay:           delay,
+		auto:            auto,
+		closeChannels:   true,
+		clock:           SystemClock,
+		ctx:             context.Background(),
+		requestsChannel: in,
+		responseChannel: out,
+	}
 
 	for _, o := range options {
 		o(queue)
 	}
 
+	// derive a cancelable context, so that the queue can be canceled
+	queue.ctx, queue.cancel = context.WithCancel(queue.ctx)
+
+	for _, hook := range queue.hooks {
+		hook(queue)
+	}
+
 	return queue
 }
 
 // processRequests, processes the incoming requests and with a build-in rate-limiter
-// to avoid any rate-limit errors from Twitter API
+// to avoid any rate-limit errors from Twitter API. It returns once the requests channel
+// is closed or the queue's context is done.
 func (q *Queue) processRequests(api *Twitter) {
-	// close queue's channels
-	defer q.Close()
+	// close the response channel, nothing else will be sent
+	defer close(q.responseChannel)
 
 	// listen channel
 	for {
 		// capture input request and channel state
-		req := <-q.requestsChannel
-
-		// send the request on twitter api
-		err := api.apiDo(req)
+		var req *Request
+		select {
+		case <-q.ctx.Done():
+			return
+		case r, ok := <-q.requestsChannel:
+			if !ok {
+				return
+			}
+			req = r
+		}
 
-		// capture request errors
-		if err != nil && q.auto {
-			code := parseErrorCode(err)
-			if code == 420 || code == 429 || code >= 500 {
-				// if err == rate limit then add req to channel again and continue
-				go func(c *Queue, r *Request) {
-					c.requestsChannel <- r
-				}(q, req)
-
-				// delay next request for q.delay duration
-				<-time.After(q.delay)
-
-				// go to start
-				continue
-			} else {
-				q.responseChannel <- &Response{req.Results, err}
-				break
+		// use the cached response, if any, or send the request on twitter api
+		var err error
+		cached := api.loadCache(req)
+		if !cached {
+			err = q.dispatch(api, req)
+			if q.ctx.Err() != nil {
+				return
+			}
+			if err == nil {
+				api.storeCache(req)
 			}
 		}
 
 		// add response to channel
-		q.responseChannel <- &Response{req.Results, err}
+		select {
+		case <-q.ctx.Done():
+			return
+		case q.responseChannel <- &Response{req.Results, err, req.RateLimit}:
+		}
+
+		// cache hits don't count against the rate limit
+		if cached {
+			continue
+		}
 
 		// throttle requests to avoid rate-limit errors
-		<-time.After(q.rate)
+		d := q.next(req.RateLimit)
+		q.allow(d)
+		if !q.wait(d) {
+			return
+		}
+	}
+}
+
+// send sends the request on twitter api, retrying it according to the queue's
+// retry policy for as long as the queue's context is alive.
+func (q *Queue) send(api *Twitter, req *Request) (err error) {
+	q.record(func(stats *QueueStats) { stats.Pending++ })
+	defer q.record(func(stats *QueueStats) {
+		stats.Pending--
+		if err == nil {
+			stats.Succeeded++
+		} else {
+			stats.Failed++
+		}
+	})
+
+	for attempt := 1; ; attempt++ {
+		// wait for a client with rate limit budget on the endpoint
+		client, err := q.acquire(api, req)
+		if err != nil {
+			return err
+		}
+
+		// bind the request to the queue's context
+		req.WithContext(q.ctx)
+		err = client.apiDo(req)
+
+		q.record(func(stats *QueueStats) {
+			stats.Sent++
+			if !req.RateLimit.IsZero() {
+				stats.RateLimit = req.RateLimit
+			}
+		})
+
+		// share the rate limit information with the other queues
+		budget := client.bucket(req)
+		budget.update(req.RateLimit)
+
+		// fail over to another client of the pool, if the client was revoked
+		if err != nil && api.failover(client, err) {
+			q.record(func(stats *QueueStats) { stats.Retried++ })
+			req.ResetResults()
+			continue
+		}
+
+		// capture request errors
+		if err == nil || q.ctx.Err() != nil {
+			return err
+		}
+
+		policy := q.retryPolicy()
+		if policy == nil || !policy.retryable(err) {
+			return err
+		}
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			return err
+		}
+
+		// the `Retry-After` header overrides the policy's backoff
+		delay := retryAfter(err)
+		if delay <= 0 {
+			delay = policy.backoff(attempt)
+		}
+
+		if IsRateLimited(err) {
+			// exhaust the budget until the rate limit window resets,
+			// or for the backoff duration if the reset time is unknown
+			reset := req.RateLimit.Reset
+			if reset.IsZero() || retryAfter(err) > 0 {
+				reset = q.clock.Now().Add(delay)
+			}
+			budget.exhaust(reset)
+		} else {
+			q.allow(delay)
+			if !q.wait(delay) {
+				return q.ctx.Err()
+			}
+		}
+
+		// reset request's results and try again
+		q.record(func(stats *QueueStats) { stats.Retried++ })
+		req.ResetResults()
+	}
+}
+
+// retryPolicy returns the queue's retry policy. Without one, queues with auto set
+// retry rate limit and server errors every q.delay duration, up to defaultMaxAttempts.
+func (q *Queue) retryPolicy() *RetryPolicy {
+	if q.retry != nil {
+		return q.retry
+	}
+	if !q.auto {
+		return nil
+	}
+	return &RetryPolicy{MaxAttempts: defaultMaxAttempts, BaseBackoff: q.delay, MaxBackoff: q.delay}
+}
+
+// acquire blocks until the queue is not paused and a client has rate limit budget for the request
+// and returns it. It fails once the queue's context is done, or when there is no client left to use.
+func (q *Queue) acquire(api *Twitter, req *Request) (*Twitter, error) {
+	for {
+		if !q.paused() {
+			return nil, q.ctx.Err()
+		}
+
+		client, d, err := api.reserve(req, q.clock.Now())
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return client, nil
+		}
+		q.allow(d)
+		if !q.wait(d) {
+			return nil, q.ctx.Err()
+		}
+	}
+}
+
+// next returns the duration to wait before sending the next request. The budget of the
+// endpoint is tracked by the client's buckets, so requests are sent at full speed unless
+// the rate was set explicitly or twitter didn't return any rate limit headers.
+func (q *Queue) next(info RateLimitInfo) time.Duration {
+	if q.throttle || info.IsZero() {
+		return q.rate
+	}
+	return 0
+}
+
+// wait blocks for d duration and reports whether the queue's
+// context is still alive afterwards.
+func (q *Queue) wait(d time.Duration) bool {
+	timer := q.clock.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-q.ctx.Done():
+		return false
+	case <-timer.C():
+		return true
 	}
 }
 
-func parseErrorCode(err error) int {
-	var code int
+// run starts the requests channel processor with req as the first request and
+// returns the results channel. If tokenParam is set, run will follow the
+// `next_token` of each response by setting the tokenParam parameter, until there
+// are no more pages or one of the queue's caps is reached. The channel is closed
+// once, when the job is done or the queue's context is done.
+func (q *Queue) run(api *Twitter, req *Request, tokenParam string) chan Result {
+	// create the temp results channel
+	results := make(chan Result)
 
-	e := strings.Split(err.Error(), " - ")
+	// start the requests channel processor
+	go q.processRequests(api)
 
-	if len(e) > 0 {
-		code, err := strconv.Atoi(e[0])
+	// async process the response channel
+	go (func(r chan Result) {
+		// on done release the queue's context
+		defer q.cancel()
+		// on done close the results channel
+		defer close(r)
+		// close requests channel, stopping the processor
+		defer close(q.requestsChannel)
+
+		// send the result, unless the queue's context is done
+		send := func(result Result) bool {
+			select {
+			case <-q.ctx.Done():
+				return false
+			case r <- result:
+				return true
+			}
+		}
+
+		// resume from the queue's checkpoint, if any
+		checkpoint, err := q.resume(req, tokenParam)
 		if err != nil {
-			return code
+			send(Result{Err: err})
+			return
+		}
+
+		// the job is done if the checkpoint already reached the caps
+		if q.reached(checkpoint) {
+			if err := q.checkpoint(checkpoint, tokenParam, ""); err != nil {
+				send(Result{Err: err, PageIndex: checkpoint.Pages})
+			}
+			return
+		}
+		q.prepare(req, checkpoint)
+
+		// add the 1st request to the channel
+		select {
+		case <-q.ctx.Done():
+			return
+		case q.requestsChannel <- req:
+		}
+
+		// listen channel
+		for {
+			// capture the response and channel state
+			var res *Response
+			select {
+			case <-q.ctx.Done():
+				return
+			case r, ok := <-q.responseChannel:
+				// break the loop if the channel is closed
+				if !ok {
+					return
+				}
+				res = r
+			}
+
+			// send the error, the job ends with the failed page
+			if res.Error != nil {
+				send(Result{Err: res.Error, PageIndex: checkpoint.Pages, RateLimit: res.RateLimit})
+				return
+			}
+
+			// follow the next page, if any, until the max results are reached
+			next := ""
+			if tokenParam != "" && res.Results.Meta != nil {
+				next = res.Results.Meta.NextToken
+			}
+			// apply the caps of the queue to the results
+			previous := *checkpoint
+			if q.capped(&res.Results, checkpoint) {
+				next = ""
+			}
+
+			// persist the progress before sending the results,
+			// so that they are never sent again on resume
+			follow := next
+			if !q.auto {
+				follow = ""
+			}
+			if err := q.checkpoint(checkpoint, tokenParam, follow); err != nil {
+				send(Result{Err: err, PageIndex: previous.Pages, RateLimit: res.RateLimit})
+				return
+			}
+
+			// send the results to the results channel
+			if !send(Result{Page: &res.Results, PageIndex: previous.Pages, NextToken: next, RateLimit: res.RateLimit}) {
+				// the results were never sent, restore the previous checkpoint
+				q.checkpoint(&previous, tokenParam, previous.NextToken)
+				return
+			}
+
+			// if there is a next page, transform the original request object
+			// by setting the tokenParam parameter to get the next page
+			if follow != "" {
+				// create new url values and add the pagination token
+				nv := url.Values{}
+				nv.Add(tokenParam, follow)
+
+				// update request's url Values
+				req.UpdateURLValues(nv)
+				q.prepare(req, checkpoint)
+				// reset request's results
+				req.ResetResults()
+
+				// add next request to the channel
+				select {
+				case <-q.ctx.Done():
+					return
+				case q.requestsChannel <- req:
+				}
+
+				//go to start
+				continue
+			}
+			// we are done! break the loop and close the channel
+			return
 		}
+	})(results)
+
+	// return the results channel
+	return results
+}
+
+// pageSizes holds the minimum, default and maximum `max_results` of each paginated endpoint template
+var pageSizes = map[string]struct{ min, def, max int }{
+	"/users/:id/followers":  {1, 100, 1000},
+	"/users/:id/following":  {1, 100, 1000},
+	"/users/:id/mentions":   {5, 10, 100},
+	"/users/:id/tweets":     {5, 10, 100},
+	"/tweets/search/recent": {10, 10, 100},
+	"/tweets/search/all":    {10, 10, 500},
+}
+
+// limitResults lowers the `max_results` parameter of the request, if the page would go past
+// the queue's max results, given the number of results sent so far. The page size can't go
+// below the endpoint's minimum, so the results past the max are dropped by dropResults.
+func (q *Queue) limitResults(req *Request, sent int) {
+	size, ok := pageSizes[req.Endpoint]
+	if !ok || q.maxResults <= 0 {
+		return
+	}
+
+	n := size.def
+	if v, err := strconv.Atoi(req.Req.URL.Query().Get("max_results")); err == nil {
+		n = v
 	}
 
-	return code
+	remaining := q.maxResults - sent
+	if remaining >= n {
+		return
+	}
+	if remaining < size.min {
+		remaining = size.min
+	}
+
+	req.UpdateURLValues(url.Values{"max_results": {strconv.Itoa(remaining)}})
+}
+
+// dropResults drops the objects of a page past the queue's max results,
+// given the number of results sent so far.
+func (q *Queue) dropResults(objects []json.RawMessage, sent int) []json.RawMessage {
+	remaining := q.maxResults - sent
+	if q.maxResults <= 0 || len(objects) <= remaining {
+		return objects
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	return objects[:remaining]
 }
 
-// Close closes requests and response channels
+// Close stops the queue, like Cancel. The requests and response channels are closed
+// by the queue itself once it stops, so Close is safe to call more than once.
 func (q *Queue) Close() {
-	close(q.requestsChannel)
-	close(q.responseChannel)
+	q.Cancel()
 }
@@ -0,0 +1,256 @@
+package twitter
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitInfo holds the rate limit information Twitter API returns
+// with each response, through the `x-rate-limit-*` headers.
+type RateLimitInfo struct {
+	// Limit is the rate limit ceiling for the endpoint
+	Limit int
+	// Remaining is the number of requests left for the current window
+	Remaining int
+	// Reset is the time the current window resets
+	Reset time.Time
+}
+
+// IsZero reports whether the rate limit headers were missing from the response.
+func (r RateLimitInfo) IsZero() bool {
+	return r.Limit == 0 && r.Remaining == 0 && r.Reset.IsZero()
+}
+
+// parseRateLimit returns the rate limit information of the response headers.
+func parseRateLimit(h http.Header) RateLimitInfo {
+	var info RateLimitInfo
+
+	if v, err := strconv.Atoi(h.Get("x-rate-limit-limit")); err == nil {
+		info.Limit = v
+	}
+	if v, err := strconv.Atoi(h.Get("x-rate-limit-remaining")); err == nil {
+		info.Remaining = v
+	}
+	if v, err := strconv.ParseInt(h.Get("x-rate-limit-reset"), 10, 64); err == nil {
+		info.Reset = time.Unix(v, 0)
+	}
+
+	return info
+}
+
+// RateLimitStatus holds the rate limit status of an endpoint, as returned
+// by the `application/rate_limit_status.json` endpoint.
+type RateLimitStatus struct {
+	// Resource is the family of the endpoint, e.g. `users`
+	Resource string
+	// Endpoint is the endpoint template, e.g. `/users/:id`
+	Endpoint string
+	// Limit is the rate limit ceiling for the endpoint
+	Limit int
+	// Remaining is the number of requests left for the current window
+	Remaining int
+	// Reset is the time the current window resets
+	Reset time.Time
+}
+
+// rateLimitStatusResponse is the response of the `application/rate_limit_status.json` endpoint
+type rateLimitStatusResponse struct {
+	Resources map[string]map[string]struct {
+		Limit     int   `json:"limit"`
+		Remaining int   `json:"remaining"`
+		Reset     int64 `json:"reset"`
+	} `json:"resources"`
+}
+
+// statuses returns the rate limit status of each endpoint, sorted by resource and endpoint.
+func (r *rateLimitStatusResponse) statuses() []*RateLimitStatus {
+	var statuses []*RateLimitStatus
+	for resource, endpoints := range r.Resources {
+		for endpoint, l := range endpoints {
+			statuses = append(statuses, &RateLimitStatus{
+				Resource:  resource,
+				Endpoint:  endpoint,
+				Limit:     l.Limit,
+				Remaining: l.Remaining,
+				Reset:     time.Unix(l.Reset, 0),
+			})
+		}
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Resource != statuses[j].Resource {
+			return statuses[i].Resource < statuses[j].Resource
+		}
+		return statuses[i].Endpoint < statuses[j].Endpoint
+	})
+
+	return statuses
+}
+
+// Authentication contexts, rate limits are tracked separately for each one
+const (
+	authApp  = "app"
+	authUser = "user"
+)
+
+// rateLimitWindow is the rate limit window of Twitter API
+const rateLimitWindow = 15 * time.Minute
+
+// endpointLimits holds the default number of requests (app, user) allowed per window,
+// for each endpoint template. They are used until Twitter returns the rate limit headers.
+var endpointLimits = map[string]struct{ app, user int }{
+	"GET /users/:id/followers":         {15, 15},
+	"GET /users/:id/following":         {15, 15},
+	"GET /users":                       {300, 900},
+	"GET /users/by":                    {300, 900},
+	"GET /users/:id":                   {300, 900},
+	"GET /users/by/username/:username": {300, 900},
+	"GET /users/me":                    {0, 75},
+	"GET /users/:id/mentions":          {450, 180},
+	"GET /users/:id/tweets":            {1500, 900},
+	"GET /tweets":                      {300, 900},
+	"GET /tweets/:id":                  {300, 900},
+	"GET /tweets/search/recent":        {450, 180},
+	"GET /tweets/search/all":           {300, 300},
+	"GET /tweets/search/stream":        {50, 50},
+	"GET /tweets/search/stream/rules":  {450, 450},
+	"POST /tweets/search/stream/rules": {450, 450},
+	"GET /tweets/sample/stream":        {50, 50},
+}
+
+// bucket holds the rate limit budget of a single endpoint. The budget is refilled
+// once the window resets and is kept in sync with the headers of each response.
+type bucket struct {
+	mu        sync.Mutex
+	limit     int
+	remaining int
+	reset     time.Time
+	window    time.Duration
+}
+
+// newBucket creates a bucket with limit requests per window. A zero limit
+// means the budget is unknown, until the first response is observed.
+func newBucket(limit int, window time.Duration) *bucket {
+	return &bucket{limit: limit, remaining: limit, window: window}
+}
+
+// reserve takes a request from the budget and returns zero, or the duration to
+// wait before trying again if the budget of the current window is exhausted.
+func (b *bucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// refill the budget once the window resets
+	if !b.reset.IsZero() && !now.Before(b.reset) {
+		b.remaining = b.limit
+		b.reset = time.Time{}
+	}
+
+	if b.reset.IsZero() {
+		// unknown budget, let the request through
+		if b.limit == 0 {
+			return 0
+		}
+		// the window starts with the first request
+		b.reset = now.Add(b.window)
+	}
+
+	if b.remaining > 0 {
+		b.remaining--
+		return 0
+	}
+
+	return b.reset.Sub(now) + resetMargin
+}
+
+// peek returns the remaining budget of the current window without taking from it,
+// or the duration to wait if it is exhausted. An unknown budget is reported as unlimited.
+func (b *bucket) peek(now time.Time) (int, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// the window has reset
+	if b.reset.IsZero() || !now.Before(b.reset) {
+		if b.limit == 0 {
+			return math.MaxInt32, 0
+		}
+		return b.limit, 0
+	}
+
+	if b.remaining > 0 {
+		return b.remaining, 0
+	}
+
+	return 0, b.reset.Sub(now) + resetMargin
+}
+
+// update syncs the budget with the rate limit information of a response.
+func (b *bucket) update(info RateLimitInfo) {
+	if info.IsZero() {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if info.Limit > 0 {
+		b.limit = info.Limit
+	}
+
+	// a new window, trust the headers, otherwise keep the lowest value
+	// since other requests may be in-flight
+	if !info.Reset.Equal(b.reset) {
+		b.reset = info.Reset
+		b.remaining = info.Remaining
+	} else if info.Remaining < b.remaining {
+		b.remaining = info.Remaining
+	}
+}
+
+// exhaust marks the budget as exhausted until reset, after a rate limit error.
+func (b *bucket) exhaust(reset time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.remaining = 0
+	b.reset = reset
+}
+
+// rateLimits is a registry of rate limit buckets shared by all the
+// requests made by a client, keyed by authentication context and endpoint.
+type rateLimits struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// newRateLimits creates an empty rate limits registry.
+func newRateLimits() *rateLimits {
+	return &rateLimits{buckets: make(map[string]*bucket)}
+}
+
+// get returns the bucket of endpoint for the auth context, creating it if needed.
+func (r *rateLimits) get(auth, endpoint string) *bucket {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := auth + " " + endpoint
+	if b, ok := r.buckets[key]; ok {
+		return b
+	}
+
+	limit := 0
+	if l, ok := endpointLimits[endpoint]; ok {
+		limit = l.app
+		if auth == authUser {
+			limit = l.user
+		}
+	}
+
+	b := newBucket(limit, rateLimitWindow)
+	r.buckets[key] = b
+	return b
+}
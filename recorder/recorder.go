@@ -0,0 +1,413 @@
+// Package recorder provides an HTTP record/replay transport, to run code built on
+// the twitter package deterministically, without network access.
+//
+// In record mode the requests are sent to Twitter API and each request/response pair is
+// kept in memory, until Stop saves them to a cassette file with credentials scrubbed.
+// In replay mode the responses are served from the cassette. Streaming responses are
+// recorded chunk by chunk and replayed the same way.
+//
+//	rec, _ := recorder.New("testdata/followers.json", recorder.ModeAuto)
+//	defer rec.Stop()
+//
+//	api, _ := twitter.NewTwitter(key, secret, twitter.WithTransport(rec))
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cvcio/twitter"
+)
+
+// Mode is the mode of a Recorder
+type Mode int
+
+// Recorder modes
+const (
+	// ModeReplay serves the responses from the cassette, failing on unknown requests
+	ModeReplay Mode = iota
+	// ModeRecord sends the requests and records them, replacing the cassette on Stop
+	ModeRecord
+	// ModeAuto replays the cassette if it exists, otherwise records it
+	ModeAuto
+)
+
+// ErrNoInteraction is returned in replay mode, for requests missing from the cassette.
+var ErrNoInteraction = errors.New("recorder: no recorded interaction for the request")
+
+// Cassette holds the recorded interactions, in the order they were sent.
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// Interaction is a recorded request/response pair.
+type Interaction struct {
+	Request  *Request  `json:"request"`
+	Response *Response `json:"response"`
+	replayed bool
+}
+
+// Request is a recorded request.
+type Request struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// Response is a recorded response. The body of chunked responses of unknown
+// length, such as streams, is kept in Chunks, as it was read.
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Status     string      `json:"status"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	Chunks     []string    `json:"chunks,omitempty"`
+}
+
+// Option is a Recorder option
+type Option func(*Recorder)
+
+// WithTransport (default: http.DefaultTransport) sets the transport used in record mode.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(r *Recorder) {
+		r.transport = transport
+	}
+}
+
+// WithScrubber adds a scrubber, called on each interaction before the cassette is saved,
+// to remove sensitive data that the default scrubber doesn't know about.
+func WithScrubber(scrubber func(*Interaction)) Option {
+	return func(r *Recorder) {
+		r.scrubbers = append(r.scrubbers, scrubber)
+	}
+}
+
+// WithMatcher (default: same method and url) sets the function matching requests
+// to recorded interactions in replay mode. The url of the request is scrubbed.
+func WithMatcher(matcher func(req *http.Request, recorded *Request) bool) Option {
+	return func(r *Recorder) {
+		r.matcher = matcher
+	}
+}
+
+// Recorder is an http.RoundTripper recording or replaying request/response pairs.
+type Recorder struct {
+	mu        sync.Mutex
+	mode      Mode
+	path      string
+	transport http.RoundTripper
+	scrubbers []func(*Interaction)
+	matcher   func(*http.Request, *Request) bool
+	cassette  *Cassette
+	bodies    map[*Interaction]*recordBody
+}
+
+// New returns a new Recorder for the cassette at path. In replay mode, or in auto mode
+// when the cassette exists, the cassette is loaded.
+func New(path string, mode Mode, options ...Option) (*Recorder, error) {
+	r := &Recorder{
+		mode:      mode,
+		path:      path,
+		transport: http.DefaultTransport,
+		scrubbers: []func(*Interaction){Scrub},
+		matcher:   matchMethodURL,
+		cassette:  &Cassette{},
+		bodies:    make(map[*Interaction]*recordBody),
+	}
+
+	for _, o := range options {
+		o(r)
+	}
+
+	if r.mode == ModeAuto {
+		r.mode = ModeRecord
+		if _, err := os.Stat(path); err == nil {
+			r.mode = ModeReplay
+		}
+	}
+
+	if r.mode == ModeReplay {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, r.cassette); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// Mode returns the mode of the recorder, either ModeRecord or ModeReplay.
+func (r *Recorder) Mode() Mode {
+	return r.mode
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if r.mode == ModeReplay {
+		return r.replay(req)
+	}
+	return r.record(req)
+}
+
+// record sends the request and records the interaction, the response
+// body is recorded while it's read.
+func (r *Recorder) record(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		b, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+		req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	}
+
+	resp, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	interaction := &Interaction{
+		Request: &Request{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: req.Header.Clone(),
+			Body:   string(body),
+		},
+		Response: &Response{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Header:     resp.Header.Clone(),
+		},
+	}
+
+	rb := &recordBody{ReadCloser: resp.Body, chunked: resp.ContentLength < 0}
+	resp.Body = rb
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	r.bodies[interaction] = rb
+	r.mu.Unlock()
+
+	return resp, nil
+}
+
+// replay returns the response of the first matching interaction that hasn't been
+// replayed yet, or of the last matching interaction if all of them have been.
+func (r *Recorder) replay(req *http.Request) (*http.Response, error) {
+	scrubbed := *req
+	scrubbed.URL, _ = url.Parse(twitter.RedactURL(req.URL))
+
+	r.mu.Lock()
+	var match *Interaction
+	for _, i := range r.cassette.Interactions {
+		if !r.matcher(&scrubbed, i.Request) {
+			continue
+		}
+		match = i
+		if !i.replayed {
+			break
+		}
+	}
+	if match != nil {
+		match.replayed = true
+	}
+	r.mu.Unlock()
+
+	if match == nil {
+		return nil, fmt.Errorf("%w: %s %s", ErrNoInteraction, req.Method, scrubbed.URL)
+	}
+
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	res := match.Response
+	resp := &http.Response{
+		StatusCode:    res.StatusCode,
+		Status:        res.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        res.Header.Clone(),
+		ContentLength: int64(len(res.Body)),
+		Request:       req,
+	}
+	if resp.Header == nil {
+		resp.Header = http.Header{}
+	}
+
+	if res.Chunks == nil {
+		resp.Body = ioutil.NopCloser(strings.NewReader(res.Body))
+		return resp, nil
+	}
+
+	// serve the chunks one by one, until the request is canceled
+	resp.ContentLength = -1
+	pr, pw := io.Pipe()
+	go func() {
+		for _, chunk := range res.Chunks {
+			select {
+			case <-req.Context().Done():
+				pw.CloseWithError(req.Context().Err())
+				return
+			default:
+			}
+			if _, err := pw.Write([]byte(chunk)); err != nil {
+				return
+			}
+		}
+		pw.Close()
+	}()
+	resp.Body = pr
+
+	return resp, nil
+}
+
+// Stop saves the cassette in record mode, scrubbing each interaction. Response bodies
+// are saved as far as they have been read, so streams should be stopped first.
+func (r *Recorder) Stop() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, i := range r.cassette.Interactions {
+		if rb, ok := r.bodies[i]; ok {
+			i.Response.Body, i.Response.Chunks = rb.recorded()
+		}
+		for _, scrub := range r.scrubbers {
+			scrub(i)
+		}
+	}
+
+	b, err := json.MarshalIndent(r.cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(r.path, b, 0644)
+}
+
+// matchMethodURL matches requests with the same method and url
+func matchMethodURL(req *http.Request, recorded *Request) bool {
+	return req.Method == recorded.Method && req.URL.String() == recorded.URL
+}
+
+// scrubbedKeys are the keys of the form encoded and json bodies that carry credentials
+var scrubbedKeys = []string{
+	"access_token", "refresh_token", "oauth_token", "oauth_token_secret", "oauth_verifier",
+	"code", "code_verifier", "client_secret",
+}
+
+// Scrub is the default scrubber, redacting credential headers and url parameters, and the
+// credentials of form encoded and json request and response bodies, such as token exchanges.
+func Scrub(i *Interaction) {
+	if u, err := url.Parse(i.Request.URL); err == nil {
+		i.Request.URL = twitter.RedactURL(u)
+	}
+
+	// OAuth 1.0a token responses are form encoded, whatever their content type
+	oauth1 := strings.Contains(i.Request.URL, "/oauth/")
+	i.Request.Body = scrubBody(i.Request.Header, i.Request.Body, oauth1)
+	i.Response.Body = scrubBody(i.Response.Header, i.Response.Body, oauth1)
+
+	i.Request.Header = twitter.RedactHeader(i.Request.Header)
+	i.Response.Header = twitter.RedactHeader(i.Response.Header)
+}
+
+// scrubBody redacts the credentials of a form encoded body, or of a json body.
+func scrubBody(h http.Header, body string, form bool) string {
+	if form || strings.HasPrefix(h.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return scrubForm(body)
+	}
+	return scrubJSON(body)
+}
+
+// scrubForm redacts the credentials of form encoded bodies
+func scrubForm(s string) string {
+	v, err := url.ParseQuery(s)
+	if err != nil || len(v) == 0 {
+		return s
+	}
+
+	for _, k := range scrubbedKeys {
+		if _, ok := v[k]; ok {
+			v.Set(k, "REDACTED")
+		}
+	}
+	return v.Encode()
+}
+
+// scrubJSON redacts the credentials of json bodies, e.g. of OAuth 2.0 token responses
+func scrubJSON(s string) string {
+	var body map[string]interface{}
+	if json.Unmarshal([]byte(s), &body) != nil {
+		return s
+	}
+
+	scrubbed := false
+	for _, k := range scrubbedKeys {
+		if _, ok := body[k]; ok {
+			body[k] = "REDACTED"
+			scrubbed = true
+		}
+	}
+	if !scrubbed {
+		return s
+	}
+
+	b, _ := json.Marshal(body)
+	return string(b)
+}
+
+// recordBody records the response body as it's read
+type recordBody struct {
+	io.ReadCloser
+	mu      sync.Mutex
+	chunked bool
+	chunks  []string
+}
+
+// Read implements io.Reader
+func (b *recordBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.mu.Lock()
+		b.chunks = append(b.chunks, string(p[:n]))
+		b.mu.Unlock()
+	}
+	return n, err
+}
+
+// recorded returns the body read so far, either as a whole or in chunks
+func (b *recordBody) recorded() (string, []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.chunked {
+		return "", append([]string{}, b.chunks...)
+	}
+	return strings.Join(b.chunks, ""), nil
+}
@@ -0,0 +1,183 @@
+package recorder_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cvcio/twitter"
+	"github.com/cvcio/twitter/recorder"
+)
+
+func newServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/oauth2/token":
+			w.Write([]byte(`{"token_type":"bearer","access_token":"secret-token"}`))
+		case "/2/users/44142397":
+			w.Write([]byte(`{"data":{"id":"44142397","username":"andefined"}}`))
+		case "/2/tweets/sample/stream":
+			for i := 1; i <= 2; i++ {
+				fmt.Fprintf(w, "{\"data\":{\"id\":\"%d\",\"text\":\"tweet\"}}\r\n", i)
+				w.(http.Flusher).Flush()
+			}
+			<-r.Context().Done()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+// run looks up a user and reads 2 tweets of the sample stream
+func run(t *testing.T, rec *recorder.Recorder, baseURL string) {
+	api, err := twitter.NewTwitter("key", "secret",
+		twitter.WithBaseURL(baseURL+"/2"),
+		twitter.WithOAuthBaseURL(baseURL),
+		twitter.WithTransport(rec),
+	)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	var user *twitter.User
+	r := <-api.GetUserByID("44142397", url.Values{})
+	if r.Err != nil {
+		t.Fatalf("Twitter API Error: %v", r.Err)
+	}
+	b, _ := json.Marshal(r.Page.Data)
+	json.Unmarshal(b, &user)
+	if user == nil || user.UserName != "andefined" {
+		t.Fatalf("Recorder Error. Should have returned andefined, got %v", user)
+	}
+
+	s, err := api.GetSampleStream(url.Values{})
+	if err != nil {
+		t.Fatalf("Twitter API Stream Error: %v", err)
+	}
+	for i := 1; i <= 2; i++ {
+		d := (<-s.C).(twitter.StreamData)
+		if d.Data == nil || d.Data.ID != fmt.Sprint(i) {
+			t.Fatalf("Recorder Error. Should have streamed tweet %d, got %v", i, d.Data)
+		}
+	}
+	s.Stop()
+}
+
+func Test_Recorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	server := newServer()
+	rec, err := recorder.New(path, recorder.ModeAuto)
+	if err != nil {
+		t.Fatalf("Recorder Error: %v", err)
+	}
+	if rec.Mode() != recorder.ModeRecord {
+		t.Fatalf("Recorder Error. Should have recorded the missing cassette")
+	}
+
+	run(t, rec, server.URL)
+	if err := rec.Stop(); err != nil {
+		t.Fatalf("Recorder Error: %v", err)
+	}
+	server.Close()
+
+	b, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(b), "secret-token") {
+		t.Fatalf("Recorder Error. Credentials should have been scrubbed, got %s", b)
+	}
+
+	// replay offline, the server is closed
+	rec, err = recorder.New(path, recorder.ModeAuto)
+	if err != nil {
+		t.Fatalf("Recorder Error: %v", err)
+	}
+	if rec.Mode() != recorder.ModeReplay {
+		t.Fatalf("Recorder Error. Should have replayed the cassette")
+	}
+
+	run(t, rec, server.URL)
+
+	// unknown requests fail
+	req, _ := http.NewRequest("GET", server.URL+"/2/tweets", nil)
+	if _, err := rec.RoundTrip(req); err == nil {
+		t.Fatalf("Recorder Error. Should have failed on unknown requests")
+	}
+}
+
+func Test_Recorder_Scrub(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	secrets := []string{"secret-code", "secret-verifier", "secret-client", "secret-oauth-verifier", "secret-refresh", "secret-rotated", "secret-access"}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/2/oauth2/token":
+			w.Write([]byte(`{"token_type":"bearer","access_token":"secret-access","refresh_token":"secret-rotated","expires_in":7200}`))
+		case "/2/users/44142397":
+			w.Write([]byte(`{"data":{"id":"44142397","username":"andefined"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	rec, err := recorder.New(path, recorder.ModeRecord)
+	if err != nil {
+		t.Fatalf("Recorder Error: %v", err)
+	}
+
+	// an expired token is refreshed on the first request
+	store := twitter.NewMemoryTokenStore()
+	store.Save(&twitter.Token{AccessToken: "expired", RefreshToken: "secret-refresh", TokenType: "bearer", Expiry: time.Now().Add(-time.Hour)})
+
+	api, err := twitter.NewTwitterWithPKCE(context.Background(), &twitter.PKCEConfig{ClientID: "client-id"},
+		twitter.WithBaseURL(server.URL+"/2"),
+		twitter.WithOAuthBaseURL(server.URL),
+		twitter.WithTransport(rec),
+		twitter.WithTokenStore(store),
+	)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client: %v", err)
+	}
+	if r := <-api.GetUserByID("44142397", url.Values{}); r.Err != nil {
+		t.Fatalf("Twitter API Error: %v", r.Err)
+	}
+
+	// an authorization code exchange, whatever its path
+	client := &http.Client{Transport: rec}
+	resp, err := client.PostForm(server.URL+"/2/oauth2/token", url.Values{
+		"grant_type":     {"authorization_code"},
+		"code":           {"secret-code"},
+		"code_verifier":  {"secret-verifier"},
+		"client_secret":  {"secret-client"},
+		"oauth_verifier": {"secret-oauth-verifier"},
+	})
+	if err != nil {
+		t.Fatalf("Recorder Error: %v", err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if err := rec.Stop(); err != nil {
+		t.Fatalf("Recorder Error: %v", err)
+	}
+
+	b, _ := ioutil.ReadFile(path)
+	for _, secret := range secrets {
+		if strings.Contains(string(b), secret) {
+			t.Fatalf("Recorder Error. %s should have been scrubbed, got %s", secret, b)
+		}
+	}
+	if !strings.Contains(string(b), "refresh_token") {
+		t.Fatalf("Recorder Error. Should have recorded the refresh exchange, got %s", b)
+	}
+}
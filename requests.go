@@ -2,6 +2,7 @@ package twitter
 
 import (
 	"bytes"
+	"context"
 	"net/http"
 	"net/url"
 	"strings"
@@ -9,8 +10,12 @@ import (
 
 // Request Struct
 type Request struct {
-	Req     *http.Request
-	Results Data
+	Req       *http.Request
+	Results   Data
+	RateLimit RateLimitInfo
+	// Endpoint is the endpoint template of the request, relative
+	// to the base url, e.g. `/users/:id/followers`
+	Endpoint string
 }
 
 // NewRquest returns a new Request struct
@@ -31,7 +36,7 @@ func NewRquest(method, url string, v url.Values, body []byte) (*Request, error)
 		request.Header.Set("Content-Type", "application/json")
 	}
 	request.URL.RawQuery = query.Encode()
-	return &Request{request, Data{}}, nil
+	return &Request{Req: request, Results: Data{}}, nil
 }
 
 // UpdateURLValues updates request's query values
@@ -47,3 +52,22 @@ func (r *Request) UpdateURLValues(v url.Values) {
 func (r *Request) ResetResults() {
 	r.Results = Data{}
 }
+
+// rewind resets the request's body, so that the request can be sent again
+func (r *Request) rewind() error {
+	if r.Req.GetBody == nil {
+		return nil
+	}
+
+	body, err := r.Req.GetBody()
+	if err != nil {
+		return err
+	}
+	r.Req.Body = body
+	return nil
+}
+
+// WithContext binds the request to ctx, so that the request is canceled once ctx is done
+func (r *Request) WithContext(ctx context.Context) {
+	r.Req = r.Req.WithContext(ctx)
+}
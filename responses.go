@@ -6,8 +6,25 @@ import (
 
 // Response Struct
 type Response struct {
-	Results Data
-	Error   error
+	Results   Data
+	Error     error
+	RateLimit RateLimitInfo
+}
+
+// Result holds a page of a job, or the error that ended it, as sent on the
+// channel returned by the Get methods.
+type Result struct {
+	// Page is the page of results, nil on error
+	Page *Data
+	// Err is the error that ended the job
+	Err error
+	// PageIndex is the zero based index of the page in the job
+	PageIndex int
+	// NextToken is the pagination token of the next page, or an empty
+	// string if there are no more pages or a cap of the queue was reached
+	NextToken string
+	// RateLimit is the rate limit information of the response
+	RateLimit RateLimitInfo
 }
 
 // Meta Struct
@@ -22,6 +39,8 @@ type Data struct {
 	Data     *interface{} `json:"data,omitempty"`
 	Includes *Includes    `json:"includes,omitempty"`
 	Meta     *Meta        `json:"meta,omitempty"`
+	// Errors holds the partial errors of successful responses, e.g. the ids not found by a lookup
+	Errors []*Problem `json:"errors,omitempty"`
 }
 
 // Twitter Specific Data
@@ -266,25 +285,25 @@ type Rules struct {
 }
 
 type Media struct {
-	MediaKey         string
-	Type             string
-	URL              string
-	DurationMS       int
-	Height           int
-	Width            int
-	NonPublicMetrics *MediaMetrics
-	OrganicMetrics   *MediaMetrics
-	PromotedMetrics  *MediaMetrics
-	PublicMetrics    *MediaMetrics
-	PreviewImageURL  string
-	AltText          string
-	Variants         []*MediaVariant
+	MediaKey         string          `json:"media_key,omitempty"`
+	Type             string          `json:"type,omitempty"`
+	URL              string          `json:"url,omitempty"`
+	DurationMS       int             `json:"duration_ms,omitempty"`
+	Height           int             `json:"height,omitempty"`
+	Width            int             `json:"width,omitempty"`
+	NonPublicMetrics *MediaMetrics   `json:"non_public_metrics,omitempty"`
+	OrganicMetrics   *MediaMetrics   `json:"organic_metrics,omitempty"`
+	PromotedMetrics  *MediaMetrics   `json:"promoted_metrics,omitempty"`
+	PublicMetrics    *MediaMetrics   `json:"public_metrics,omitempty"`
+	PreviewImageURL  string          `json:"preview_image_url,omitempty"`
+	AltText          string          `json:"alt_text,omitempty"`
+	Variants         []*MediaVariant `json:"variants,omitempty"`
 }
 
 type MediaVariant struct {
-	BitRate     int
-	ContentType string
-	URL         string
+	BitRate     int    `json:"bit_rate,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	URL         string `json:"url,omitempty"`
 }
 
 /*
@@ -0,0 +1,103 @@
+package twitter
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy controls how a queue retries failed requests.
+type RetryPolicy struct {
+	// MaxAttempts (default: unlimited) is the maximum number of attempts per request,
+	// including the first one. Zero or less retries for as long as the queue's context is alive.
+	MaxAttempts int
+	// BaseBackoff is the delay before the first retry, doubled on each attempt
+	BaseBackoff time.Duration
+	// MaxBackoff (default: unlimited) caps the delay between attempts
+	MaxBackoff time.Duration
+	// Jitter, between 0 and 1, randomly shortens each delay by up to that fraction,
+	// so that concurrent queues don't retry at the same time
+	Jitter float64
+	// Retryable (default: DefaultRetryable) reports whether a response
+	// with the given status code should be retried
+	Retryable func(statusCode int) bool
+	// NoNetworkRetry disables retrying network errors, such as connection resets and timeouts
+	NoNetworkRetry bool
+}
+
+// DefaultRetryable retries rate limit errors (420 and 429) and server errors (5xx).
+func DefaultRetryable(statusCode int) bool {
+	return statusCode == 420 || statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// defaultMaxAttempts is the maximum number of attempts per request of the default retry policy
+const defaultMaxAttempts = 5
+
+// WithRetryPolicy (default: retry rate limit and server errors every @delay, up to 5 attempts, when auto is set)
+// sets the policy used to retry failed requests. A `Retry-After` header returned by
+// Twitter API overrides the backoff of the policy.
+func WithRetryPolicy(policy RetryPolicy) QueueOption {
+	return func(q *Queue) {
+		q.retry = &policy
+	}
+}
+
+// retryable reports whether err should be retried according to the policy.
+func (p *RetryPolicy) retryable(err error) bool {
+	var e *APIError
+	if errors.As(err, &e) {
+		retryable := p.Retryable
+		if retryable == nil {
+			retryable = DefaultRetryable
+		}
+		return retryable(e.StatusCode)
+	}
+
+	return !p.NoNetworkRetry && isNetworkError(err)
+}
+
+// backoff returns the delay before the given attempt, starting at 1 for the first retry.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseBackoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+
+	if p.Jitter > 0 {
+		d -= time.Duration(rand.Float64() * p.Jitter * float64(d))
+	}
+
+	return d
+}
+
+// retryAfter returns the `Retry-After` duration of err, if any.
+func retryAfter(err error) time.Duration {
+	var e *APIError
+	if errors.As(err, &e) {
+		return e.RetryAfter
+	}
+	return 0
+}
+
+// isNetworkError reports whether err is a transient network error. Every error of the
+// http client is a *url.Error, which implements net.Error, so only its timeouts are
+// retried, not the permanent ones such as an unsupported scheme or a certificate error.
+func isNetworkError(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
@@ -11,50 +11,24 @@ import (
 // Official Documentation: https://developer.twitter.com/en/docs/twitter-api/tweets/search/api-reference/get-tweets-search-recent
 // Authentication Methods: OAuth 1.0a User Context, OAuth 2.0 Bearer Token
 // Rate Limit: 450/15m (app), 180/15m (user)
-func (api *Twitter) GetTweetsSearchRecent(v url.Values, options ...QueueOption) (chan *Data, chan error) {
+func (api *Twitter) GetTweetsSearchRecent(v url.Values, options ...QueueOption) chan Result {
 	// create the queue to process requests
 	queue := NewQueue(15*time.Minute/450, 15*time.Minute, true, make(chan *Request), make(chan *Response), options...)
-	// create the temp results channel
-	data := make(chan *Data)
-	errors := make(chan error)
 	// create the request object
 	request, _ := NewRquest("GET", fmt.Sprintf("%s/tweets/search/recent", api.baseURL), v, nil)
-	// start the requests channel processor
-	go queue.processRequests(api)
-	// add the 1st request to the channel
-	queue.requestsChannel <- request
-
-	// async process the response channel
-	go (func(q *Queue, d chan *Data, e chan error, req *Request) {
-		// on done close channels
-		// close data channel
-		defer close(d)
-		// close error channel
-		defer close(e)
-
-		// listen channel
-		for {
-			// capture the response and channel state
-			res, ok := <-q.responseChannel
-			// break the loop if the channel is closed
-			if !ok {
-				break
-			}
-
-			// send the results to the data channel
-			d <- &res.Results
-			// send errors to error channel
-			if res.Error != nil {
-				e <- res.Error
-			}
-
-			// we are done! break the loop and close the channels
-			break
-		}
-	})(queue, data, errors, request)
+	request.Endpoint = "/tweets/search/recent"
+	// start the requests channel processor and return the results channel,
+	// following the `next_token` of each page
+	return queue.run(api, request, "next_token")
+}
 
-	// return the data channel
-	return data, errors
+// GetTweetsSearchRecentPaginator is like GetTweetsSearchRecent, but returns a Paginator to fetch the pages one at a time.
+func (api *Twitter) GetTweetsSearchRecentPaginator(v url.Values, options ...QueueOption) *Paginator {
+	// create the request object
+	request, _ := NewRquest("GET", fmt.Sprintf("%s/tweets/search/recent", api.baseURL), v, nil)
+	request.Endpoint = "/tweets/search/recent"
+	// create the paginator, following the `next_token` of each page
+	return newPaginator(api, request, "next_token", 15*time.Minute/450, 15*time.Minute, options...)
 }
 
 // GetTweetsSearchAll returns the complete history of public Tweets matching a search query;
@@ -65,48 +39,22 @@ func (api *Twitter) GetTweetsSearchRecent(v url.Values, options ...QueueOption)
 // Official Documentation: https://developer.twitter.com/en/docs/twitter-api/tweets/full-archive-search/api-reference/get-tweets-search-all
 // Authentication Methods: OAuth 2.0 Bearer Token
 // Rate Limit: 300/15m (app), 1/1s (user)
-func (api *Twitter) GetTweetsSearchAll(v url.Values, options ...QueueOption) (chan *Data, chan error) {
+func (api *Twitter) GetTweetsSearchAll(v url.Values, options ...QueueOption) chan Result {
 	// create the queue to process requests
 	queue := NewQueue(15*time.Minute/300, 15*time.Minute, true, make(chan *Request), make(chan *Response), options...)
-	// create the temp results channel
-	data := make(chan *Data)
-	errors := make(chan error)
 	// create the request object
 	request, _ := NewRquest("GET", fmt.Sprintf("%s/tweets/search/all", api.baseURL), v, nil)
-	// start the requests channel processor
-	go queue.processRequests(api)
-	// add the 1st request to the channel
-	queue.requestsChannel <- request
-
-	// async process the response channel
-	go (func(q *Queue, d chan *Data, e chan error, req *Request) {
-		// on done close channels
-		// close data channel
-		defer close(d)
-		// close error channel
-		defer close(e)
-
-		// listen channel
-		for {
-			// capture the response and channel state
-			res, ok := <-q.responseChannel
-			// break the loop if the channel is closed
-			if !ok {
-				break
-			}
-
-			// send the results to the data channel
-			d <- &res.Results
-			// send errors to error channel
-			if res.Error != nil {
-				e <- res.Error
-			}
-
-			// we are done! break the loop and close the channels
-			break
-		}
-	})(queue, data, errors, request)
+	request.Endpoint = "/tweets/search/all"
+	// start the requests channel processor and return the results channel,
+	// following the `next_token` of each page
+	return queue.run(api, request, "next_token")
+}
 
-	// return the data channel
-	return data, errors
+// GetTweetsSearchAllPaginator is like GetTweetsSearchAll, but returns a Paginator to fetch the pages one at a time.
+func (api *Twitter) GetTweetsSearchAllPaginator(v url.Values, options ...QueueOption) *Paginator {
+	// create the request object
+	request, _ := NewRquest("GET", fmt.Sprintf("%s/tweets/search/all", api.baseURL), v, nil)
+	request.Endpoint = "/tweets/search/all"
+	// create the paginator, following the `next_token` of each page
+	return newPaginator(api, request, "next_token", 15*time.Minute/300, 15*time.Minute, options...)
 }
@@ -3,37 +3,112 @@ package twitter
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
+	"time"
 )
 
+// ErrStreamStalled is delivered on the C channel of a stream that received
+// no data, nor heartbeats, for the stall timeout of the stream.
+var ErrStreamStalled = errors.New("twitter: stream stalled")
+
+// Stream holds the state of a streaming connection. Tweets (or errors) are
+// delivered on C, which is closed once the stream stops.
 type Stream struct {
-	api *Twitter
-	C   chan interface{}
-	run bool
+	api     *Twitter
+	C       chan interface{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	parent  context.Context
+	stopped chan struct{}
+	stop    sync.Once
+	clock   Clock
+	stall   time.Duration
+	mu      sync.Mutex
+	seen    time.Time
+	sending bool
+}
+
+// StreamOption stream options struct
+type StreamOption func(*Stream)
+
+// WithStreamContext (default: context.Background()) binds the stream to ctx. Once ctx is done
+// the connection is closed and C is closed.
+func WithStreamContext(ctx context.Context) StreamOption {
+	return func(s *Stream) {
+		s.ctx = ctx
+	}
+}
+
+// WithStreamClock (default: SystemClock) sets the clock of the stream, used for the stall detection.
+func WithStreamClock(clock Clock) StreamOption {
+	return func(s *Stream) {
+		s.clock = clock
+	}
+}
+
+// WithStallTimeout (default: disabled) closes the stream once it receives no data, nor heartbeats,
+// for d, delivering ErrStreamStalled on C before it's closed, so that the caller can reconnect.
+// Twitter sends a heartbeat every 20 seconds and recommends a timeout of 90 seconds.
+func WithStallTimeout(d time.Duration) StreamOption {
+	return func(s *Stream) {
+		s.stall = d
+	}
 }
 
+// Stop stops the stream and closes the underlying connection
 func (stream *Stream) Stop() {
-	stream.run = false
+	stream.cancel()
+	stream.stop.Do(func() { close(stream.stopped) })
 }
 
 func (stream *Stream) start(urlStr string, v url.Values) error {
-	stream.run = true
-
 	request, err := NewRquest("GET", urlStr, v, nil)
 	if err != nil {
 		return err
 	}
-	r, err := stream.api.client.Do(request.Req)
-	if err != nil {
-		return err
-	}
+	request.Endpoint = strings.TrimPrefix(urlStr, stream.api.baseURL)
+	request.WithContext(stream.ctx)
+
+	// streams are bound to a single client of a pool, failing over
+	// to the rest of the pool if the client's credentials are revoked
+	pool := stream.api
+	for {
+		client := pool
+		if pool.pool != nil {
+			if client, err = pool.pool.pick(request, stream.clock.Now()); err != nil {
+				return err
+			}
+		}
+
+		// streams are long lived, so they are not bound to the client's timeout
+		r, err := client.chain(func(req *Request) (*http.Response, error) {
+			return client.client.Do(req.Req)
+		})(request)
+		if err != nil {
+			return err
+		}
+
+		if pool.pool != nil && r.StatusCode == http.StatusUnauthorized {
+			err := newAPIError(r)
+			r.Body.Close()
+			if !pool.failover(client, err) {
+				return err
+			}
+			continue
+		}
 
-	go stream.listen(r)
+		stream.api = client
+		go stream.listen(r)
 
-	return nil
+		return nil
+	}
 }
 
 func jsonToKnownType(j []byte) interface{} {
@@ -48,10 +123,18 @@ func (stream *Stream) listen(response *http.Response) {
 	}
 	defer close(stream.C)
 
+	// close the connection once the stream stalls
+	stalled := make(chan bool, 1)
+	if stream.stall > 0 {
+		stream.touch(false)
+		go stream.watch(stalled)
+	}
+
 	// created the scanner to read each line
 	scanner := bufio.NewScanner(response.Body)
-	for scanner.Scan() && stream.run {
+	for scanner.Scan() && stream.ctx.Err() == nil {
 		line := scanner.Bytes()
+		stream.touch(false)
 
 		// Contuinue if empty bytes returned from the stream
 		// Read more about consuming streaming data: https://developer.twitter.com/en/docs/tutorials/consuming-streaming-data
@@ -59,18 +142,85 @@ func (stream *Stream) listen(response *http.Response) {
 			continue
 		}
 
-		stream.C <- jsonToKnownType(bytes.TrimRight(line, "\r\n"))
+		// a slow consumer isn't a stall, so the time spent on the send isn't counted
+		stream.touch(true)
+		select {
+		case <-stream.ctx.Done():
+			return
+		case stream.C <- jsonToKnownType(bytes.TrimRight(line, "\r\n")):
+		}
+		stream.touch(false)
+	}
+
+	// stop the watch, if it's still running, and report the stall
+	stream.cancel()
+	if stream.stall > 0 && <-stalled {
+		select {
+		case <-stream.parent.Done():
+		case <-stream.stopped:
+		case stream.C <- ErrStreamStalled:
+		}
+	}
+}
+
+// touch records the time of the last line received, or delivered, and whether its send is pending
+func (stream *Stream) touch(sending bool) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	stream.seen, stream.sending = stream.clock.Now(), sending
+}
+
+// watch closes the connection once no line is received for the stall timeout, while no send is pending,
+// or stops once the stream's context is done. It reports whether the stream stalled on stalled.
+func (stream *Stream) watch(stalled chan<- bool) {
+	timer := stream.clock.NewTimer(stream.stall)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-stream.ctx.Done():
+			stalled <- false
+			return
+		case <-timer.C():
+		}
+
+		stream.mu.Lock()
+		idle := stream.clock.Now().Sub(stream.seen)
+		if stream.sending {
+			idle = 0
+		}
+		stream.mu.Unlock()
+
+		if idle >= stream.stall {
+			stalled <- true
+			stream.cancel()
+			return
+		}
+		timer.Reset(stream.stall - idle)
 	}
 }
 
-func (api Twitter) newStream(urlStr string, v url.Values) (*Stream, error) {
+func (api Twitter) newStream(urlStr string, v url.Values, options ...StreamOption) (*Stream, error) {
 	stream := Stream{
-		api: &api,
-		C:   make(chan interface{}),
+		api:     &api,
+		C:       make(chan interface{}),
+		ctx:     context.Background(),
+		stopped: make(chan struct{}),
+		clock:   SystemClock,
+	}
+
+	for _, o := range options {
+		o(&stream)
 	}
 
+	// derive a cancelable context, so that Stop closes the connection
+	stream.parent = stream.ctx
+	stream.ctx, stream.cancel = context.WithCancel(stream.ctx)
+
 	err := stream.start(urlStr, v)
 	if err != nil {
+		stream.cancel()
 		return nil, err
 	}
 	return &stream, nil
@@ -81,9 +231,9 @@ func (api Twitter) newStream(urlStr string, v url.Values) (*Stream, error) {
 // Official Documentation: https://developer.twitter.com/en/docs/twitter-api/tweets/filtered-stream/api-reference/get-tweets-search-stream
 // Authentication Methods: OAuth 2.0 Bearer Token
 // Rate Limit: 50/15m (app)
-func (api *Twitter) GetFilterStream(v url.Values) (*Stream, error) {
+func (api *Twitter) GetFilterStream(v url.Values, options ...StreamOption) (*Stream, error) {
 	return api.newStream(
-		fmt.Sprintf("%s/tweets/search/stream", api.baseURL), v,
+		fmt.Sprintf("%s/tweets/search/stream", api.baseURL), v, options...,
 	)
 }
 
@@ -93,7 +243,14 @@ func (api *Twitter) GetFilterStream(v url.Values) (*Stream, error) {
 // Authentication Methods: OAuth 2.0 Bearer Token
 // Rate Limit: 450/15m (app)
 func (api *Twitter) GetFilterStreamRules(v url.Values) (*Rules, error) {
+	return api.GetFilterStreamRulesContext(context.Background(), v)
+}
+
+// GetFilterStreamRulesContext is like GetFilterStreamRules, but the request is canceled once ctx is done.
+func (api *Twitter) GetFilterStreamRulesContext(ctx context.Context, v url.Values) (*Rules, error) {
 	request, _ := NewRquest("GET", fmt.Sprintf("%s/tweets/search/stream/rules", api.baseURL), v, nil)
+	request.Endpoint = "/tweets/search/stream/rules"
+	request.WithContext(ctx)
 
 	res, err := api.apiDoWithResponse(request)
 	if err != nil {
@@ -115,11 +272,18 @@ func (api *Twitter) GetFilterStreamRules(v url.Values) (*Rules, error) {
 // Authentication Methods: OAuth 2.0 Bearer Token
 // Rate Limit: 450/15m (app)
 func (api *Twitter) PostFilterStreamRules(v url.Values, r *Rules) (*Rules, error) {
+	return api.PostFilterStreamRulesContext(context.Background(), v, r)
+}
+
+// PostFilterStreamRulesContext is like PostFilterStreamRules, but the request is canceled once ctx is done.
+func (api *Twitter) PostFilterStreamRulesContext(ctx context.Context, v url.Values, r *Rules) (*Rules, error) {
 	body, err := json.Marshal(r)
 	if err != nil {
 		return nil, err
 	}
 	request, _ := NewRquest("POST", fmt.Sprintf("%s/tweets/search/stream/rules", api.baseURL), v, body)
+	request.Endpoint = "/tweets/search/stream/rules"
+	request.WithContext(ctx)
 
 	res, err := api.apiDoWithResponse(request)
 	if err != nil {
@@ -140,8 +304,8 @@ func (api *Twitter) PostFilterStreamRules(v url.Values, r *Rules) (*Rules, error
 // Official Documentation: https://developer.twitter.com/en/docs/twitter-api/tweets/sampled-stream/api-reference/get-tweets-sample-stream
 // Authentication Methods: OAuth 2.0 Bearer Token
 // Rate Limit: 50/15m (app)
-func (api *Twitter) GetSampleStream(v url.Values) (*Stream, error) {
+func (api *Twitter) GetSampleStream(v url.Values, options ...StreamOption) (*Stream, error) {
 	return api.newStream(
-		fmt.Sprintf("%s/tweets/sample/stream", api.baseURL), v,
+		fmt.Sprintf("%s/tweets/sample/stream", api.baseURL), v, options...,
 	)
 }
@@ -0,0 +1,251 @@
+package twitter
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrTokenNotFound is returned by a TokenStore when no token has been saved yet.
+var ErrTokenNotFound = errors.New("token store: token not found")
+
+// Token holds the persisted credentials of a client, either an OAuth 1.0a
+// access token and secret, or an OAuth 2.0 access and refresh token.
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenSecret  string    `json:"token_secret,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	TokenType    string    `json:"token_type,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+	UserID       string    `json:"user_id,omitempty"`
+	ScreenName   string    `json:"screen_name,omitempty"`
+}
+
+// TokenStore persists the credentials of a client, so that they outlive the process.
+// Load returns ErrTokenNotFound if no token has been saved yet. Save is called after
+// each authorization and each time an OAuth 2.0 token is refreshed.
+type TokenStore interface {
+	Load() (*Token, error)
+	Save(token *Token) error
+}
+
+// WithTokenStore sets the store used by NewTwitterWithPKCE and NewTwitterWithSignIn.
+// A stored token skips the authorization flow, while new and refreshed tokens are saved.
+func WithTokenStore(store TokenStore) ClientOption {
+	return func(api *Twitter) {
+		api.tokenStore = store
+	}
+}
+
+// loadToken returns the token of the client's store, or nil if there is none.
+func (api *Twitter) loadToken() (*Token, error) {
+	if api.tokenStore == nil {
+		return nil, nil
+	}
+
+	token, err := api.tokenStore.Load()
+	if errors.Is(err, ErrTokenNotFound) {
+		return nil, nil
+	}
+
+	return token, err
+}
+
+// saveToken saves the token to the client's store, if any.
+func (api *Twitter) saveToken(token *Token) error {
+	if api.tokenStore == nil {
+		return nil
+	}
+	return api.tokenStore.Save(token)
+}
+
+// newOAuth2Token converts an OAuth 2.0 token to a Token.
+func newOAuth2Token(t *oauth2.Token) *Token {
+	return &Token{
+		AccessToken:  t.AccessToken,
+		RefreshToken: t.RefreshToken,
+		TokenType:    t.TokenType,
+		Expiry:       t.Expiry,
+	}
+}
+
+// oauth2Token converts the Token to an OAuth 2.0 token.
+func (t *Token) oauth2Token() *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  t.AccessToken,
+		RefreshToken: t.RefreshToken,
+		TokenType:    t.TokenType,
+		Expiry:       t.Expiry,
+	}
+}
+
+// MemoryTokenStore keeps the token in memory, mostly useful for tests
+// or for sharing a token between clients of the same process.
+type MemoryTokenStore struct {
+	mu    sync.Mutex
+	token *Token
+}
+
+// NewMemoryTokenStore returns a new, empty, MemoryTokenStore
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{}
+}
+
+// Load implements TokenStore
+func (s *MemoryTokenStore) Load() (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token == nil {
+		return nil, ErrTokenNotFound
+	}
+
+	t := *s.token
+	return &t, nil
+}
+
+// Save implements TokenStore
+func (s *MemoryTokenStore) Save(token *Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := *token
+	s.token = &t
+	return nil
+}
+
+// FileTokenStore keeps the token in a JSON file, optionally encrypted at rest with AES-GCM.
+type FileTokenStore struct {
+	mu   sync.Mutex
+	path string
+	key  []byte
+}
+
+// NewFileTokenStore returns a new FileTokenStore saving the token at path. If key is not
+// empty, the file is encrypted with it. The key must be 32 random bytes (AES-256), kept
+// out of the file's reach, e.g. in a secrets manager; passphrases must not be used as keys.
+func NewFileTokenStore(path string, key []byte) (*FileTokenStore, error) {
+	if len(key) > 0 && len(key) != 32 {
+		return nil, errors.New("token store: the key must be 32 bytes")
+	}
+
+	store := &FileTokenStore{path: path}
+	if len(key) > 0 {
+		store.key = append([]byte(nil), key...)
+	}
+
+	return store, nil
+}
+
+// Load implements TokenStore
+func (s *FileTokenStore) Load() (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if s.key != nil {
+		if b, err = s.decrypt(b); err != nil {
+			return nil, err
+		}
+	}
+
+	token := new(Token)
+	if err := json.Unmarshal(b, token); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// Save implements TokenStore. The file is replaced atomically and
+// is only readable by the current user.
+func (s *FileTokenStore) Save(token *Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	if s.key != nil {
+		if b, err = s.encrypt(b); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// encrypt seals b, prepending the random nonce.
+func (s *FileTokenStore) encrypt(b []byte) ([]byte, error) {
+	gcm, err := s.gcm()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, b, nil), nil
+}
+
+// decrypt opens b, sealed by encrypt.
+func (s *FileTokenStore) decrypt(b []byte) ([]byte, error) {
+	gcm, err := s.gcm()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) < gcm.NonceSize() {
+		return nil, errors.New("token store: invalid encrypted token")
+	}
+
+	nonce, b := b[:gcm.NonceSize()], b[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, b, nil)
+}
+
+// gcm returns the AES-GCM cipher of the store's key.
+func (s *FileTokenStore) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
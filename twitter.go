@@ -3,10 +3,11 @@ package twitter
 import (
 	"context"
 	"encoding/json"
-	"errors"
-	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/mrjones/oauth"
@@ -17,6 +18,8 @@ import (
 // Constants
 const (
 	BaseURL            = "https://api.twitter.com/2"
+	V1BaseURL          = "https://api.twitter.com/1.1"
+	OAuthBaseURL       = "https://api.twitter.com"
 	RequestTokenURL    = "https://api.twitter.com/oauth/request_token"
 	AuthorizeTokenURL  = "https://api.twitter.com/oauth/authorize"
 	AccessTokenURL     = "https://api.twitter.com/oauth/access_token"
@@ -24,34 +27,190 @@ const (
 	RateLimitStatusURL = "https://api.twitter.com/1.1/application/rate_limit_status.json"
 )
 
+// tokenTimeout is the default timeout of token requests
+const tokenTimeout = 30 * time.Second
+
 // Twitter API Client
 type Twitter struct {
-	client  *http.Client
-	baseURL string
-	queue   *Queue
+	client      *http.Client
+	baseURL     string
+	v1URL       string
+	oauthURL    string
+	httpClient  *http.Client
+	transport   http.RoundTripper
+	userAgent   string
+	proxy       *url.URL
+	timeout     time.Duration
+	tokenStore  TokenStore
+	queue       *Queue
+	auth        string
+	limits      *rateLimits
+	pool        *pool
+	middlewares []Middleware
+	cache       Cache
+	cacheTTL    CacheTTL
+	flights     *flightGroup
+	batcher     *batcher
+	clock       Clock
+}
+
+// ClientOption client options struct
+type ClientOption func(*Twitter)
+
+// WithBaseURL (default: https://api.twitter.com/2) sets the base url of Twitter API v2 endpoints
+func WithBaseURL(u string) ClientOption {
+	return func(api *Twitter) {
+		api.baseURL = strings.TrimRight(u, "/")
+	}
+}
+
+// WithV1BaseURL (default: https://api.twitter.com/1.1) sets the base url of Twitter API v1.1 endpoints,
+// such as the rate limit status endpoint
+func WithV1BaseURL(u string) ClientOption {
+	return func(api *Twitter) {
+		api.v1URL = strings.TrimRight(u, "/")
+	}
+}
+
+// WithOAuthBaseURL (default: https://api.twitter.com) sets the base url of the authentication endpoints,
+// such as `/oauth2/token` and `/oauth/request_token`
+func WithOAuthBaseURL(u string) ClientOption {
+	return func(api *Twitter) {
+		api.oauthURL = strings.TrimRight(u, "/")
+	}
+}
+
+// WithHTTPClient sets the underlying HTTP client, used both for token requests and as the
+// transport of the authenticated requests. Keep in mind that a client timeout also applies
+// to streaming connections, use WithTimeout instead.
+func WithHTTPClient(client *http.Client) ClientOption {
+	return func(api *Twitter) {
+		api.httpClient = client
+	}
+}
+
+// WithTransport (default: http.DefaultTransport) sets the underlying transport of all requests
+func WithTransport(transport http.RoundTripper) ClientOption {
+	return func(api *Twitter) {
+		api.transport = transport
+	}
+}
+
+// WithUserAgent sets the User-Agent header of all requests
+func WithUserAgent(userAgent string) ClientOption {
+	return func(api *Twitter) {
+		api.userAgent = userAgent
+	}
+}
+
+// WithProxy routes all requests through the proxy. It only applies to
+// *http.Transport transports, which is the default.
+func WithProxy(proxy *url.URL) ClientOption {
+	return func(api *Twitter) {
+		api.proxy = proxy
+	}
+}
+
+// WithTimeout sets the timeout of token requests (default: 30 seconds) and of each
+// API request (default: none). It doesn't apply to streaming connections.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(api *Twitter) {
+		api.timeout = timeout
+	}
+}
+
+// newTwitter creates a new Twitter client for the auth context, applying the options.
+func newTwitter(auth string, options ...ClientOption) *Twitter {
+	api := &Twitter{
+		baseURL:  BaseURL,
+		v1URL:    V1BaseURL,
+		oauthURL: OAuthBaseURL,
+		auth:     auth,
+		limits:   newRateLimits(),
+		flights:  newFlightGroup(),
+		clock:    SystemClock,
+	}
+
+	for _, o := range options {
+		o(api)
+	}
+
+	return api
+}
+
+// newHTTPClient returns the underlying HTTP client, configured by the client options.
+func (api *Twitter) newHTTPClient() *http.Client {
+	client := &http.Client{}
+	if api.httpClient != nil {
+		*client = *api.httpClient
+	}
+
+	if api.transport != nil {
+		client.Transport = api.transport
+	}
+
+	if api.proxy != nil {
+		transport := client.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		if t, ok := transport.(*http.Transport); ok {
+			t = t.Clone()
+			t.Proxy = http.ProxyURL(api.proxy)
+			client.Transport = t
+		}
+	}
+
+	if api.userAgent != "" {
+		client.Transport = &userAgentTransport{api.userAgent, client.Transport}
+	}
+
+	return client
+}
+
+// userAgentTransport sets the User-Agent header of each request
+type userAgentTransport struct {
+	userAgent string
+	base      http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	// a RoundTripper must not modify the request
+	r := req.Clone(req.Context())
+	r.Header.Set("User-Agent", t.userAgent)
+	return base.RoundTrip(r)
 }
 
 // NewTwitter returns a new Twitter API v2 Client using OAuth 2.0 based authentication.
 // This method is usufull when you only need to make Application-Only requests.
 // Official Documentation: https://developer.twitter.com/en/docs/authentication/oauth-2-0
-func NewTwitter(consumerKey, consumerSecret string) (*Twitter, error) {
+func NewTwitter(consumerKey, consumerSecret string, options ...ClientOption) (*Twitter, error) {
 	// create new context
 	ctx := context.Background()
 
 	// init new Twitter client
-	api := &Twitter{
-		baseURL: BaseURL,
-	}
+	api := newTwitter(authApp, options...)
 
 	// oauth2 configures a client that uses app credentials to keep a fresh token
 	config := &clientcredentials.Config{
 		ClientID:     consumerKey,
 		ClientSecret: consumerSecret,
-		TokenURL:     TokenURL,
+		TokenURL:     api.oauthURL + "/oauth2/token",
 	}
 
 	// Use the custom HTTP client when requesting a token.
-	httpClient := &http.Client{Timeout: 30 * time.Second}
+	httpClient := api.newHTTPClient()
+	if api.timeout > 0 {
+		httpClient.Timeout = api.timeout
+	} else if httpClient.Timeout == 0 {
+		httpClient.Timeout = tokenTimeout
+	}
 	ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
 
 	// http.Client will automatically authorize Requests
@@ -59,75 +218,15 @@ func NewTwitter(consumerKey, consumerSecret string) (*Twitter, error) {
 	return api, nil
 }
 
-// NewTwitter returns a new Twitter API v2 Client using OAuth 2.0 based authentication.
-// This method is usufull when you only need to make Application-Only requests.
-// Official Documentation: https://developer.twitter.com/en/docs/authentication/oauth-2-0
-// Scopes: https://developer.twitter.com/en/docs/authentication/oauth-2-0/authorization-code
-// func NewTwitterWithPKCE(consumerKey, consumerSecret, accessToken, accessTokenSecret string) (*Twitter, error) {
-// 	// create new context
-// 	ctx := context.Background()
-
-// 	// init new Twitter client
-// 	api := &Twitter{
-// 		baseURL: BaseURL,
-// 	}
-
-// 	// oauth2 configures a client that uses app credentials to keep a fresh token
-// 	config := &oauth2.Config{
-// 		ClientID:     consumerKey,
-// 		ClientSecret: consumerSecret,
-// 		Scopes: []string{
-// 			"tweet.read",
-// 			"users.read",
-// 			"offline.access",
-// 		},
-// 		Endpoint: oauth2.Endpoint{
-// 			AuthURL:  AuthorizeTokenURL,
-// 			TokenURL: TokenURL,
-// 		},
-// 	}
-
-// 	// Redirect user to consent page to ask for permission
-// 	// for the scopes specified above.
-// 	url := config.AuthCodeURL("state", oauth2.AccessTypeOffline,
-// 		oauth2.SetAuthURLParam("code_challenge", "challenge"),
-// 		oauth2.SetAuthURLParam("code_challenge_method", "plain"),
-// 		oauth2.SetAuthURLParam("response_type", "code"),
-// 	)
-// 	fmt.Printf("Visit the URL for the auth dialog: %v", url)
-
-// 	// Use the authorization code that is pushed to the redirect
-// 	// URL. Exchange will do the handshake to retrieve the
-// 	// initial access token. The HTTP Client returned by
-// 	// conf.Client will refresh the token as necessary.
-// 	var code string
-// 	if _, err := fmt.Scan(&code); err != nil {
-// 		return nil, err
-// 	}
-// 	tok, err := config.Exchange(ctx, code)
-// 	if err != nil {
-// 		return nil, err
-// 	}
-
-// 	api.client = config.Client(ctx, tok)
-// 	return api, nil
-// }
-
 // NewTwitterWithContext returns a new Twitter API v2 Client using OAuth 1.0 based authentication.
 // This method is useful when you need to make API requests, on behalf of a Twitter account.
 // Official Documentation: https://developer.twitter.com/en/docs/authentication/oauth-1-0a
-func NewTwitterWithContext(consumerKey, consumerSecret, accessToken, accessTokenSecret string) (*Twitter, error) {
+func NewTwitterWithContext(consumerKey, consumerSecret, accessToken, accessTokenSecret string, options ...ClientOption) (*Twitter, error) {
 	// init new Twitter client
-	api := &Twitter{
-		baseURL: BaseURL,
-	}
+	api := newTwitter(authUser, options...)
 
 	// create the consumer
-	oauthConsumer := oauth.NewConsumer(consumerKey, consumerSecret, oauth.ServiceProvider{
-		RequestTokenUrl:   RequestTokenURL,
-		AuthorizeTokenUrl: AuthorizeTokenURL,
-		AccessTokenUrl:    AccessTokenURL,
-	})
+	oauthConsumer := api.newConsumer(consumerKey, consumerSecret)
 
 	//set tokens
 	oauthToken := oauth.AccessToken{
@@ -146,20 +245,146 @@ func NewTwitterWithContext(consumerKey, consumerSecret, accessToken, accessToken
 	return api, nil
 }
 
-// GetClient Get HTTP Client
+// newConsumer creates an OAuth 1.0a consumer, sending signed requests with the custom HTTP client.
+func (api *Twitter) newConsumer(consumerKey, consumerSecret string) *oauth.Consumer {
+	return oauth.NewCustomHttpClientConsumer(consumerKey, consumerSecret, oauth.ServiceProvider{
+		RequestTokenUrl:   api.oauthURL + "/oauth/request_token",
+		AuthorizeTokenUrl: api.oauthURL + "/oauth/authorize",
+		AccessTokenUrl:    api.oauthURL + "/oauth/access_token",
+	}, api.newHTTPClient())
+}
+
+// GetClient Get HTTP Client, for pools the HTTP Client of the first client
 func (api *Twitter) GetClient() *http.Client {
 	return api.client
 }
 
-// VerifyCredentials returns bool upon successful request. This method will make a request
-// on the rate-limit endpoint since there is no official token validation method.
-func (api *Twitter) VerifyCredentials() (bool, error) {
-	response, err := api.client.Get(RateLimitStatusURL)
+// Credentials holds the identity and the rate limit status of a client, as returned by VerifyCredentials.
+type Credentials struct {
+	// User is the authenticated user, only for user context clients
+	User *User
+	// RateLimits is the rate limit status of each endpoint, sorted by resource and endpoint
+	RateLimits []*RateLimitStatus
+}
+
+// VerifyCredentials validates the client's credentials, returning an *APIError if they are rejected.
+// Since there is no official token validation method, it requests the rate limit status of the client.
+// The status describes the v1.1 endpoints, so the budgets of the v2 endpoints are learned from the
+// `x-rate-limit-*` headers of their responses instead. For user context clients the authenticated user is returned too.
+func (api *Twitter) VerifyCredentials() (*Credentials, error) {
+	return api.VerifyCredentialsContext(context.Background())
+}
+
+// VerifyCredentialsContext is like VerifyCredentials, but the requests are canceled once ctx is done.
+func (api *Twitter) VerifyCredentialsContext(ctx context.Context) (*Credentials, error) {
+	request, err := NewRquest("GET", api.v1URL+"/application/rate_limit_status.json", nil, nil)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	request.WithContext(ctx)
+	request.Endpoint = "/application/rate_limit_status"
+
+	body, err := api.apiDoWithResponse(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var status rateLimitStatusResponse
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, err
+	}
+
+	credentials := &Credentials{RateLimits: status.statuses()}
+
+	if api.auth != authUser {
+		return credentials, nil
+	}
+
+	// get the authenticated user
+	request, err = NewRquest("GET", api.baseURL+"/users/me", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.WithContext(ctx)
+	request.Endpoint = "/users/me"
+
+	body, err = api.apiDoWithResponse(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var me struct {
+		Data *User `json:"data"`
+	}
+	if err := json.Unmarshal(body, &me); err != nil {
+		return nil, err
 	}
-	defer response.Body.Close()
-	return err == nil, nil
+	credentials.User = me.Data
+
+	return credentials, nil
+}
+
+// bucket returns the rate limit bucket of the request's endpoint, shared by
+// all the requests of the client on the same endpoint and auth context.
+func (api *Twitter) bucket(req *Request) *bucket {
+	if api.limits == nil || req.Endpoint == "" {
+		return newBucket(0, rateLimitWindow)
+	}
+	return api.limits.get(api.auth, req.Req.Method+" "+req.Endpoint)
+}
+
+// do sends the request with the client through the client's middlewares.
+func (api *Twitter) do(req *Request) (*http.Response, error) {
+	return api.chain(api.roundTrip)(req)
+}
+
+// roundTrip sends the request with the client, bounded by the client's timeout if any.
+func (api *Twitter) roundTrip(req *Request) (*http.Response, error) {
+	if api.timeout <= 0 {
+		return api.client.Do(req.Req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Req.Context(), api.timeout)
+	resp, err := api.client.Do(req.Req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// release the context once the body is closed
+	resp.Body = &cancelBody{resp.Body, cancel}
+	return resp, nil
+}
+
+// cancelBody cancels the request's context once the response body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close implements io.Closer
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// reserve returns the client to send the request with, taking a request from the budget of
+// the endpoint, or the duration to wait until there is budget left. Pools pick one of their clients.
+func (api *Twitter) reserve(req *Request, now time.Time) (*Twitter, time.Duration, error) {
+	if api.pool != nil {
+		return api.pool.reserve(req, now)
+	}
+	return api, api.bucket(req).reserve(now), nil
+}
+
+// failover reports whether the request that failed with err on client should be sent again with
+// another client. Only pools fail over, removing clients with revoked credentials from the rotation.
+func (api *Twitter) failover(client *Twitter, err error) bool {
+	if api.pool == nil || !IsUnauthorized(err) {
+		return false
+	}
+	return api.pool.revoke(client, err)
 }
 
 // parseResponse returns an error while unmarshaling response body to the results interface.
@@ -195,15 +420,18 @@ func (api *Twitter) parseResponseWithInterface(resp *http.Response) ([]byte, err
 // The results are processed by `parseResponse` and written to the temporary
 // `req.Results` interaface.
 func (api *Twitter) apiDo(req *Request) error {
-	resp, err := api.client.Do(req.Req)
+	resp, err := api.do(req)
 	if err != nil {
 		return err
 	}
 
 	defer resp.Body.Close()
 
+	// keep the rate limit information for the queue
+	req.RateLimit = parseRateLimit(resp.Header)
+
 	if resp.StatusCode != 200 {
-		return errors.New(fmt.Sprintf("%d - %s", resp.StatusCode, resp.Status))
+		return newAPIError(resp)
 	}
 
 	return api.parseResponse(resp, &req.Results)
@@ -213,15 +441,39 @@ func (api *Twitter) apiDo(req *Request) error {
 // The results are processed by `parseResponse` and written to the temporary
 // `req.Results` interaface.
 func (api *Twitter) apiDoWithResponse(req *Request) ([]byte, error) {
-	resp, err := api.client.Do(req.Req)
+	// route the request to one of the pool's clients, failing over
+	// to the rest of the pool if the client's credentials are revoked
+	if api.pool != nil {
+		for {
+			client, err := api.pool.pick(req, api.clock.Now())
+			if err != nil {
+				return nil, err
+			}
+
+			body, err := client.apiDoWithResponse(req)
+			if err == nil || !api.failover(client, err) {
+				return body, err
+			}
+
+			if err := req.rewind(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	resp, err := api.do(req)
 	if err != nil {
 		return nil, err
 	}
 
 	defer resp.Body.Close()
 
+	// keep the shared rate limit budget of the endpoint up to date
+	req.RateLimit = parseRateLimit(resp.Header)
+	api.bucket(req).update(req.RateLimit)
+
 	if resp.StatusCode >= 400 {
-		return nil, errors.New(fmt.Sprintf("%d - %s", resp.StatusCode, resp.Status))
+		return nil, newAPIError(resp)
 	}
 
 	return api.parseResponseWithInterface(resp)
@@ -1,14 +1,30 @@
 package twitter_test
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/cvcio/twitter"
+	"github.com/cvcio/twitter/twittertest"
 )
 
 var (
@@ -18,17 +34,6 @@ var (
 	accessTokenSecret = os.Getenv("TEST_TWITTER_ACCESS_TOKEN_SECRET")
 )
 
-// Test_API_NewAPI_Client Test New Twitter API Client
-// func Test_NewTwitter_PKCE(t *testing.T) {
-// 	api, err := twitter.NewTwitterWithPKCE(consumerKey, consumerSecret, accessToken, accessTokenSecret)
-// 	if err != nil {
-// 		t.Fatalf("Couldn't create Twitter API HTTP Client")
-// 	}
-// 	if api.GetClient() == nil {
-// 		t.Fatalf("Twitter API HTTP Client returned nil")
-// 	}
-// }
-
 // Test_API_NewAPI_Client Test New Twitter API Client
 func Test_NewTwitter_Client(t *testing.T) {
 	api, err := twitter.NewTwitter(consumerKey, consumerSecret)
@@ -53,25 +58,25 @@ func Test_NewTwitterWithContext_Client(t *testing.T) {
 // Test_API_NewAPI_VerifyCredentials Test Twitter API VerifyCredentials
 func Test_NewTwitter_VerifyCredentials(t *testing.T) {
 	api, _ := twitter.NewTwitter(consumerKey, consumerSecret)
-	ok, err := api.VerifyCredentials()
+	credentials, err := api.VerifyCredentials()
 	if err != nil {
 		t.Fatalf("Twitter API VerifyCredentials Error: %s", err.Error())
 	}
 
-	if !ok {
-		t.Fatalf("Twitter API VerifyCredentials Error: %v", ok)
+	if len(credentials.RateLimits) == 0 {
+		t.Fatalf("Twitter API VerifyCredentials Error. Should have returned the rate limit status, got %v", credentials)
 	}
 }
 
 func Test_NewTwitterWithContext_VerifyCredentials(t *testing.T) {
 	api, err := twitter.NewTwitterWithContext(consumerKey, consumerSecret, accessToken, accessTokenSecret)
-	ok, err := api.VerifyCredentials()
+	credentials, err := api.VerifyCredentials()
 	if err != nil {
 		t.Fatalf("Twitter API VerifyCredentials Error: %s", err.Error())
 	}
 
-	if !ok {
-		t.Fatalf("Twitter API VerifyCredentials Error: %v", ok)
+	if credentials.User == nil || credentials.User.ID == "" {
+		t.Fatalf("Twitter API VerifyCredentials Error. Should have returned the authenticated user, got %v", credentials.User)
 	}
 }
 
@@ -87,32 +92,20 @@ func Test_GetUserFollowers(t *testing.T) {
 	v.Add("user.fields", "created_at,description,id,location,name,pinned_tweet_id,profile_image_url,protected,public_metrics,url,username,verified")
 	v.Add("expansions", "pinned_tweet_id")
 	v.Add("tweet.fields", "created_at,id,lang,source,public_metrics")
-	res, errs := api.GetUserFollowers("44142397", v, twitter.WithRate(15*time.Minute/15), twitter.WithAuto(false)) // @andefined
+	res := api.GetUserFollowers("44142397", v, twitter.WithRate(15*time.Minute/15), twitter.WithAuto(false)) // @andefined
 
-	for {
-		r, rok := <-res
-		if !rok {
+	for r := range res {
+		if r.Err != nil {
+			t.Errorf("Twitter API Error: %v", r.Err)
 			break
 		}
 
-		if r != nil {
-			b, err := json.Marshal(r.Data)
-			if err != nil {
-				t.Errorf("json Marshal Error: %v", err)
-			}
-
-			json.Unmarshal(b, &data)
-		}
-
-		e, eok := <-errs
-		if !eok {
-			break
+		b, err := json.Marshal(r.Page.Data)
+		if err != nil {
+			t.Errorf("json Marshal Error: %v", err)
 		}
 
-		if e != nil {
-			t.Errorf("Twitter API Error: %v", e)
-			break
-		}
+		json.Unmarshal(b, &data)
 	}
 
 	if len(data) != 50 {
@@ -128,21 +121,11 @@ func Test_GetUserFollowers_Error(t *testing.T) {
 
 	v := url.Values{}
 	v.Add("max_results", "5000")
-	res, errs := api.GetUserFollowers("44142397", v, twitter.WithRate(15*time.Minute/15), twitter.WithAuto(true)) // @andefined
-
-	for {
-		_, rok := <-res
-		if !rok {
-			break
-		}
+	res := api.GetUserFollowers("44142397", v, twitter.WithRate(15*time.Minute/15), twitter.WithAuto(true)) // @andefined
 
-		e, ok := <-errs
-		if !ok {
-			break
-		}
-
-		if e == nil || !strings.Contains(e.Error(), "400") {
-			t.Fatalf("Should have returned 400, got %s instead", e.Error())
+	for r := range res {
+		if r.Err == nil || !strings.Contains(r.Err.Error(), "400") {
+			t.Fatalf("Should have returned 400, got %v instead", r.Err)
 			break
 		}
 	}
@@ -157,37 +140,22 @@ func Test_Channels(t *testing.T) {
 	size := 0
 	v := url.Values{}
 	v.Add("max_results", "1000")
-	res, errs := api.GetUserFollowing("44142397", v, twitter.WithRate(time.Minute/6), twitter.WithAuto(true)) // @andefined
-
-	for {
-		select {
-		case r, ok := <-res:
-			if !ok {
-				res = nil
-				break
-			}
-
-			var d []*twitter.User
-			b, err := json.Marshal(r.Data)
-			if err != nil {
-				t.Fatalf("json Marshal Error: %v", err)
-			}
-
-			json.Unmarshal(b, &d)
-			size += len(d)
+	res := api.GetUserFollowing("44142397", v, twitter.WithRate(time.Minute/6), twitter.WithAuto(true)) // @andefined
 
-		case e, ok := <-errs:
-			if !ok {
-				errs = nil
-				break
-			}
-			t.Errorf("Twitter API Error: %v", e)
+	for r := range res {
+		if r.Err != nil {
+			t.Errorf("Twitter API Error: %v", r.Err)
+			continue
 		}
 
-		if res == nil && errs == nil {
-			break
+		var d []*twitter.User
+		b, err := json.Marshal(r.Page.Data)
+		if err != nil {
+			t.Fatalf("json Marshal Error: %v", err)
 		}
 
+		json.Unmarshal(b, &d)
+		size += len(d)
 	}
 
 	if size < 1000 {
@@ -204,14 +172,13 @@ func Test_GetUserFollowing(t *testing.T) {
 
 	v := url.Values{}
 	v.Add("max_results", "50")
-	res, _ := api.GetUserFollowing("44142397", v, twitter.WithRate(15*time.Minute/15), twitter.WithAuto(false)) // @andefined
-	for {
-		r, ok := <-res
-		if !ok {
-			break
+	res := api.GetUserFollowing("44142397", v, twitter.WithRate(15*time.Minute/15), twitter.WithAuto(false)) // @andefined
+	for r := range res {
+		if r.Err != nil {
+			t.Fatalf("Twitter API Error: %v", r.Err)
 		}
 
-		b, err := json.Marshal(r.Data)
+		b, err := json.Marshal(r.Page.Data)
 		if err != nil {
 			t.Fatalf("json Marshal Error: %v", err)
 		}
@@ -233,15 +200,13 @@ func Test_GetUsers(t *testing.T) {
 
 	v := url.Values{}
 	v.Add("ids", "44142397,334602996")
-	res, _ := api.GetUsers(v) // @andefined, @atsipras
-	for {
-		r, ok := <-res
-
-		if !ok {
-			break
+	res := api.GetUsers(v) // @andefined, @atsipras
+	for r := range res {
+		if r.Err != nil {
+			t.Fatalf("Twitter API Error: %v", r.Err)
 		}
 
-		b, err := json.Marshal(r.Data)
+		b, err := json.Marshal(r.Page.Data)
 		if err != nil {
 			t.Fatalf("json Marshal Error: %v", err)
 		}
@@ -263,15 +228,13 @@ func Test_GetUsersBy(t *testing.T) {
 
 	v := url.Values{}
 	v.Add("usernames", "andefined,atsipras")
-	res, _ := api.GetUsersBy(v) // @andefined, @atsipras
-	for {
-		r, ok := <-res
-
-		if !ok {
-			break
+	res := api.GetUsersBy(v) // @andefined, @atsipras
+	for r := range res {
+		if r.Err != nil {
+			t.Fatalf("Twitter API Error: %v", r.Err)
 		}
 
-		b, err := json.Marshal(r.Data)
+		b, err := json.Marshal(r.Page.Data)
 		if err != nil {
 			t.Fatalf("json Marshal Error: %v", err)
 		}
@@ -292,15 +255,13 @@ func Test_GetUserByID(t *testing.T) {
 	var data *twitter.User
 
 	v := url.Values{}
-	res, _ := api.GetUserByID("44142397", v) // @andefined
-	for {
-		r, ok := <-res
-
-		if !ok {
-			break
+	res := api.GetUserByID("44142397", v) // @andefined
+	for r := range res {
+		if r.Err != nil {
+			t.Fatalf("Twitter API Error: %v", r.Err)
 		}
 
-		b, err := json.Marshal(r.Data)
+		b, err := json.Marshal(r.Page.Data)
 		if err != nil {
 			t.Fatalf("json Marshal Error: %v", err)
 		}
@@ -321,15 +282,13 @@ func Test_GetUsersByUserName(t *testing.T) {
 	var data *twitter.User
 
 	v := url.Values{}
-	res, _ := api.GetUsersByUserName("andefined", v) // @andefined
-	for {
-		r, ok := <-res
-
-		if !ok {
-			break
+	res := api.GetUsersByUserName("andefined", v) // @andefined
+	for r := range res {
+		if r.Err != nil {
+			t.Fatalf("Twitter API Error: %v", r.Err)
 		}
 
-		b, err := json.Marshal(r.Data)
+		b, err := json.Marshal(r.Page.Data)
 		if err != nil {
 			t.Fatalf("json Marshal Error: %v", err)
 		}
@@ -351,15 +310,13 @@ func Test_GetUserTweets(t *testing.T) {
 
 	v := url.Values{}
 	v.Add("max_results", "50")
-	res, _ := api.GetUserTweets("44142397", v, twitter.WithRate(15*time.Minute/1500), twitter.WithAuto(false)) // @andefined
-	for {
-		r, ok := <-res
-
-		if !ok {
-			break
+	res := api.GetUserTweets("44142397", v, twitter.WithRate(15*time.Minute/1500), twitter.WithAuto(false)) // @andefined
+	for r := range res {
+		if r.Err != nil {
+			t.Fatalf("Twitter API Error: %v", r.Err)
 		}
 
-		b, err := json.Marshal(r.Data)
+		b, err := json.Marshal(r.Page.Data)
 		if err != nil {
 			t.Fatalf("json Marshal Error: %v", err)
 		}
@@ -381,15 +338,13 @@ func Test_GetUsesMentions(t *testing.T) {
 
 	v := url.Values{}
 	v.Add("max_results", "10")
-	res, _ := api.GetUserMentions("44142397", v, twitter.WithRate(15*time.Minute/450), twitter.WithAuto(false)) // @andefined
-	for {
-		r, ok := <-res
-
-		if !ok {
-			break
+	res := api.GetUserMentions("44142397", v, twitter.WithRate(15*time.Minute/450), twitter.WithAuto(false)) // @andefined
+	for r := range res {
+		if r.Err != nil {
+			t.Fatalf("Twitter API Error: %v", r.Err)
 		}
 
-		b, err := json.Marshal(r.Data)
+		b, err := json.Marshal(r.Page.Data)
 		if err != nil {
 			t.Fatalf("json Marshal Error: %v", err)
 		}
@@ -411,15 +366,13 @@ func Test_GetTweets(t *testing.T) {
 
 	v := url.Values{}
 	v.Add("ids", "1370136892432322569,1370704815983038469")
-	res, _ := api.GetTweets(v, twitter.WithRate(15*time.Minute/300), twitter.WithAuto(false)) // https://twitter.com/andefined/status/1370136892432322569, https://twitter.com/andefined/status/1370704815983038469
-	for {
-		r, ok := <-res
-
-		if !ok {
-			break
+	res := api.GetTweets(v, twitter.WithRate(15*time.Minute/300), twitter.WithAuto(false)) // https://twitter.com/andefined/status/1370136892432322569, https://twitter.com/andefined/status/1370704815983038469
+	for r := range res {
+		if r.Err != nil {
+			t.Fatalf("Twitter API Error: %v", r.Err)
 		}
 
-		b, err := json.Marshal(r.Data)
+		b, err := json.Marshal(r.Page.Data)
 		if err != nil {
 			t.Fatalf("json Marshal Error: %v", err)
 		}
@@ -444,15 +397,13 @@ func Test_GetTweetByID(t *testing.T) {
 	var data *twitter.Tweet
 
 	v := url.Values{}
-	res, _ := api.GetTweetByID("1370136892432322569", v) // https://twitter.com/andefined/status/1370136892432322569
-	for {
-		r, ok := <-res
-
-		if !ok {
-			break
+	res := api.GetTweetByID("1370136892432322569", v) // https://twitter.com/andefined/status/1370136892432322569
+	for r := range res {
+		if r.Err != nil {
+			t.Fatalf("Twitter API Error: %v", r.Err)
 		}
 
-		b, err := json.Marshal(r.Data)
+		b, err := json.Marshal(r.Page.Data)
 		if err != nil {
 			t.Fatalf("json Marshal Error: %v", err)
 		}
@@ -475,15 +426,13 @@ func Test_GetTweetsSearchRecent(t *testing.T) {
 	v := url.Values{}
 	v.Add("query", "covid")
 	v.Add("max_results", "100")
-	res, _ := api.GetTweetsSearchRecent(v, twitter.WithAuto(false))
-	for {
-		r, ok := <-res
-
-		if !ok {
-			break
+	res := api.GetTweetsSearchRecent(v, twitter.WithAuto(false))
+	for r := range res {
+		if r.Err != nil {
+			t.Fatalf("Twitter API Error: %v", r.Err)
 		}
 
-		b, err := json.Marshal(r.Data)
+		b, err := json.Marshal(r.Page.Data)
 		if err != nil {
 			t.Fatalf("json Marshal Error: %v", err)
 		}
@@ -613,3 +562,1790 @@ func Test_DeleteFilterStreamRules(t *testing.T) {
 		t.Fatalf("Twitter API PostFilterStreamRules Error. Should have returned 0 error Error(), got %d", len(res.Errors))
 	}
 }
+
+func Test_APIError(t *testing.T) {
+	var err error = &twitter.APIError{
+		StatusCode: 429,
+		Status:     "429 Too Many Requests",
+		Title:      "Too Many Requests",
+		Detail:     "Too Many Requests",
+	}
+
+	if !twitter.IsRateLimited(err) {
+		t.Fatalf("APIError Error. Should have been rate limited, got %v", err)
+	}
+
+	if twitter.IsNotFound(err) || twitter.IsUnauthorized(err) || twitter.IsServerError(err) {
+		t.Fatalf("APIError Error. Should have only been rate limited, got %v", err)
+	}
+
+	if !strings.HasPrefix(err.Error(), "429 - ") {
+		t.Fatalf("APIError Error. Should have started with `429 - `, got %s", err.Error())
+	}
+
+	if twitter.IsRateLimited(errors.New("429 - Too Many Requests")) {
+		t.Fatalf("APIError Error. Plain errors should not be classified")
+	}
+}
+
+func Test_APIError_Response(t *testing.T) {
+	server := twittertest.NewServer()
+	defer server.Close()
+
+	server.AddUsers(&twitter.User{ID: "1", UserName: "andefined"})
+	server.Fail("GET /users/:id", 400, 1)
+	server.Fail("GET /users/:id", 429, 1)
+
+	api, err := twitter.NewTwitter(consumerKey, consumerSecret, server.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	var e *twitter.APIError
+	r := <-api.GetUserByID("1", url.Values{}, twitter.WithAuto(false))
+	if !errors.As(r.Err, &e) {
+		t.Fatalf("APIError Error. Should have returned an *APIError, got %v", r.Err)
+	}
+	if e.StatusCode != 400 || e.Title != "Bad Request" || e.Type != "https://api.twitter.com/2/problems/invalid-request" ||
+		len(e.Errors) != 1 || e.Errors[0].Message != "Bad Request" || e.Detail != "Bad Request" || !strings.HasSuffix(e.URL, "/2/users/1") {
+		t.Fatalf("APIError Error. Should have parsed the invalid request problem, got %+v", e)
+	}
+
+	r = <-api.GetUserByID("1", url.Values{}, twitter.WithAuto(false))
+	if !errors.As(r.Err, &e) || !twitter.IsRateLimited(e) {
+		t.Fatalf("APIError Error. Should have been rate limited, got %v", r.Err)
+	}
+	if e.Title != "Too Many Requests" || e.Type != "about:blank" || e.RetryAfter <= 0 || e.RateLimit.Remaining != 0 || e.RateLimit.Reset.IsZero() {
+		t.Fatalf("APIError Error. Should have parsed the rate limit problem and headers, got %+v", e)
+	}
+}
+
+func Test_ClientOptions(t *testing.T) {
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/oauth2/token":
+			w.Write([]byte(`{"token_type":"bearer","access_token":"token"}`))
+		case "/2/users/44142397":
+			userAgent = r.Header.Get("User-Agent")
+			w.Write([]byte(`{"data":{"id":"44142397","username":"andefined"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	api, err := twitter.NewTwitter(consumerKey, consumerSecret,
+		twitter.WithBaseURL(server.URL+"/2"),
+		twitter.WithOAuthBaseURL(server.URL),
+		twitter.WithUserAgent("twitter-test"),
+		twitter.WithTimeout(5*time.Second),
+	)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	var data *twitter.User
+	res := api.GetUserByID("44142397", url.Values{})
+	for r := range res {
+		if r.Err != nil {
+			t.Fatalf("Twitter API Error: %v", r.Err)
+		}
+
+		b, err := json.Marshal(r.Page.Data)
+		if err != nil {
+			t.Fatalf("json Marshal Error: %v", err)
+		}
+
+		json.Unmarshal(b, &data)
+	}
+
+	if data == nil || data.UserName != "andefined" {
+		t.Fatalf("Twitter API GetUserByID Error. Should have returned andefined, got %v", data)
+	}
+
+	if userAgent != "twitter-test" {
+		t.Fatalf("Twitter API WithUserAgent Error. Should have sent twitter-test, got %s", userAgent)
+	}
+}
+
+func Test_WithContext(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+
+	server := twittertest.NewServer()
+	server.AddUsers(&twitter.User{ID: "1", UserName: "andefined"})
+	for i := 2; i <= 251; i++ {
+		server.AddUsers(&twitter.User{ID: fmt.Sprint(i), UserName: fmt.Sprintf("user%d", i)})
+		server.Follow(fmt.Sprint(i), "1")
+	}
+
+	api, err := twitter.NewTwitter(consumerKey, consumerSecret, server.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	// canceling the context mid-crawl closes the results channel
+	ctx, cancel := context.WithCancel(context.Background())
+	res := api.GetUserFollowers("1", url.Values{"max_results": {"100"}}, twitter.WithContext(ctx))
+	if r := <-res; r.Err != nil {
+		t.Fatalf("Twitter API Error: %v", r.Err)
+	}
+	cancel()
+	for r := range res {
+		if r.Err == nil && r.PageIndex > 1 {
+			t.Fatalf("Twitter API WithContext Error. Should have stopped the crawl, got page %d", r.PageIndex)
+		}
+	}
+
+	// closing a running queue stops it, as many times as needed
+	var queue *twitter.Queue
+	res = api.GetUserFollowers("1", url.Values{"max_results": {"100"}}, twitter.WithQueueHook(func(q *twitter.Queue) { queue = q }))
+	<-res
+	queue.Close()
+	queue.Close()
+	for range res {
+	}
+
+	// and so does closing the queue of a paginator
+	pages := api.GetUserFollowersPaginator("1", url.Values{"max_results": {"100"}}, twitter.WithQueueHook(func(q *twitter.Queue) { queue = q }))
+	if _, err := pages.Next(context.Background()); err != nil {
+		t.Fatalf("Twitter API Error: %v", err)
+	}
+	queue.Close()
+
+	// canceling the context of a stream closes it
+	ctx, cancel = context.WithCancel(context.Background())
+	s, err := api.GetSampleStream(url.Values{}, twitter.WithStreamContext(ctx))
+	if err != nil {
+		t.Fatalf("Twitter API Error: %v", err)
+	}
+	server.Publish(&twitter.Tweet{ID: "1", Text: "Hello Greece"})
+	<-s.C
+	cancel()
+	for range s.C {
+	}
+
+	// no goroutines are left behind
+	server.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Fatalf("Twitter API WithContext Error. Should have left no goroutines behind, got %d instead of %d", n, goroutines)
+	}
+}
+
+func Test_NewPool(t *testing.T) {
+	// each app gets its consumer key as access token, the revoked app is rejected
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/oauth2/token":
+			key, _, _ := r.BasicAuth()
+			w.Write([]byte(`{"token_type":"bearer","access_token":"` + key + `"}`))
+		case "/2/users/44142397":
+			if r.Header.Get("Authorization") == "Bearer revoked" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"title":"Unauthorized","detail":"Unauthorized","type":"about:blank","status":401}`))
+				return
+			}
+			w.Write([]byte(`{"data":{"id":"44142397","username":"andefined"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	var clients []*twitter.Twitter
+	for _, key := range []string{"revoked", "valid"} {
+		api, err := twitter.NewTwitter(key, consumerSecret,
+			twitter.WithBaseURL(server.URL+"/2"),
+			twitter.WithOAuthBaseURL(server.URL),
+		)
+		if err != nil {
+			t.Fatalf("Couldn't create Twitter API HTTP Client")
+		}
+		clients = append(clients, api)
+	}
+
+	if _, err := twitter.NewPool(); err == nil {
+		t.Fatalf("Twitter API NewPool Error. Should have failed without clients")
+	}
+
+	api, err := twitter.NewPool(clients...)
+	if err != nil {
+		t.Fatalf("Twitter API NewPool Error: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		var data *twitter.User
+		r := <-api.GetUserByID("44142397", url.Values{})
+		if r.Err != nil {
+			t.Fatalf("Twitter API Error: %v", r.Err)
+		}
+
+		b, err := json.Marshal(r.Page.Data)
+		if err != nil {
+			t.Fatalf("json Marshal Error: %v", err)
+		}
+
+		json.Unmarshal(b, &data)
+
+		if data == nil || data.UserName != "andefined" {
+			t.Fatalf("Twitter API NewPool Error. Should have failed over and returned andefined, got %v", data)
+		}
+	}
+}
+
+func Test_NewPool_Failover(t *testing.T) {
+	server := twittertest.NewServer()
+	defer server.Close()
+
+	server.Revoke("revoked")
+
+	var clients []*twitter.Twitter
+	for _, key := range []string{"revoked", "valid"} {
+		api, err := twitter.NewTwitter(key, consumerSecret, server.ClientOptions()...)
+		if err != nil {
+			t.Fatalf("Couldn't create Twitter API HTTP Client")
+		}
+		clients = append(clients, api)
+	}
+
+	// requests outside of queues fail over
+	api, err := twitter.NewPool(clients...)
+	if err != nil {
+		t.Fatalf("Twitter API NewPool Error: %v", err)
+	}
+	if _, err := api.VerifyCredentials(); err != nil {
+		t.Fatalf("Twitter API VerifyCredentials Error: %v", err)
+	}
+
+	// and so do streams
+	api, err = twitter.NewPool(clients...)
+	if err != nil {
+		t.Fatalf("Twitter API NewPool Error: %v", err)
+	}
+	s, err := api.GetSampleStream(url.Values{})
+	if err != nil {
+		t.Fatalf("Twitter API Error: %v", err)
+	}
+	defer s.Stop()
+
+	server.Publish(&twitter.Tweet{ID: "1", Text: "Hello Greece"})
+	if d, ok := (<-s.C).(twitter.StreamData); !ok || d.Data == nil || d.Data.ID != "1" {
+		t.Fatalf("Twitter API NewPool Error. Should have failed over and streamed the tweet, got %v", d)
+	}
+
+	// until every client is revoked
+	server.Revoke("valid")
+	api, err = twitter.NewPool(clients...)
+	if err != nil {
+		t.Fatalf("Twitter API NewPool Error: %v", err)
+	}
+	if _, err := api.VerifyCredentials(); err != twitter.ErrPoolExhausted && !twitter.IsUnauthorized(err) {
+		t.Fatalf("Twitter API VerifyCredentials Error. Should have been unauthorized, got %v", err)
+	}
+}
+
+func Test_WithRetryPolicy(t *testing.T) {
+	// the server fails twice before responding
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/oauth2/token":
+			w.Write([]byte(`{"token_type":"bearer","access_token":"token"}`))
+		case "/2/users/44142397":
+			if attempts++; attempts%3 != 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(`{"data":{"id":"44142397","username":"andefined"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	api, err := twitter.NewTwitter(consumerKey, consumerSecret,
+		twitter.WithBaseURL(server.URL+"/2"),
+		twitter.WithOAuthBaseURL(server.URL),
+	)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	policy := twitter.RetryPolicy{MaxAttempts: 3, BaseBackoff: 10 * time.Millisecond, Jitter: 0.5}
+	r := <-api.GetUserByID("44142397", url.Values{}, twitter.WithRetryPolicy(policy))
+	if r.Err != nil {
+		t.Fatalf("Twitter API Error. Should have been retried, got %v", r.Err)
+	}
+
+	if attempts != 3 {
+		t.Fatalf("Twitter API WithRetryPolicy Error. Should have sent 3 requests, got %d", attempts)
+	}
+
+	policy.MaxAttempts = 2
+	r = <-api.GetUserByID("44142397", url.Values{}, twitter.WithRetryPolicy(policy))
+	if !twitter.IsServerError(r.Err) {
+		t.Fatalf("Twitter API WithRetryPolicy Error. Should have given up with a server error, got %v", r.Err)
+	}
+
+	// permanent client errors are not retried, even by the default policy
+	api, err = twitter.NewTwitter(consumerKey, consumerSecret,
+		twitter.WithBaseURL("unsupported://"+server.Listener.Addr().String()+"/2"),
+		twitter.WithOAuthBaseURL(server.URL),
+	)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	select {
+	case r = <-api.GetUserByID("44142397", url.Values{}):
+		if r.Err == nil || !strings.Contains(r.Err.Error(), "unsupported protocol scheme") {
+			t.Fatalf("Twitter API WithRetryPolicy Error. Should have returned the unsupported scheme error, got %v", r.Err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Twitter API WithRetryPolicy Error. Should not have retried the unsupported scheme error")
+	}
+}
+
+func Test_WithMiddleware(t *testing.T) {
+	var trace string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/oauth2/token":
+			w.Write([]byte(`{"token_type":"bearer","access_token":"token"}`))
+		case "/2/users/44142397":
+			trace = r.Header.Get("X-Trace-Id")
+			w.Write([]byte(`{"data":{"id":"44142397","username":"andefined"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	var logs bytes.Buffer
+	var endpoint string
+	var body []byte
+	api, err := twitter.NewTwitter(consumerKey, consumerSecret,
+		twitter.WithBaseURL(server.URL+"/2"),
+		twitter.WithOAuthBaseURL(server.URL),
+		twitter.WithMiddleware(
+			twitter.LoggingMiddleware(log.New(&logs, "", 0)),
+			twitter.HeaderMiddleware(http.Header{"X-Trace-Id": {"trace"}, "Authorization": {"Bearer secret"}}),
+			twitter.TimingMiddleware(func(req *twitter.Request, resp *http.Response, err error, d time.Duration) {
+				endpoint = req.Endpoint
+			}),
+			twitter.CaptureMiddleware(func(req *twitter.Request, resp *http.Response, b []byte) {
+				body = b
+			}),
+		),
+	)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	var data *twitter.User
+	r := <-api.GetUserByID("44142397", url.Values{})
+	if r.Err != nil {
+		t.Fatalf("Twitter API Error: %v", r.Err)
+	}
+
+	b, _ := json.Marshal(r.Page.Data)
+	json.Unmarshal(b, &data)
+
+	if data == nil || data.UserName != "andefined" {
+		t.Fatalf("Twitter API WithMiddleware Error. Captured body should have been parsed, got %v", data)
+	}
+
+	if trace != "trace" || endpoint != "/users/:id" || !bytes.Contains(body, []byte("andefined")) {
+		t.Fatalf("Twitter API WithMiddleware Error. Got trace %q, endpoint %q, body %q", trace, endpoint, body)
+	}
+
+	if strings.Contains(logs.String(), "secret") || !strings.Contains(logs.String(), "Bearer REDACTED") {
+		t.Fatalf("Twitter API LoggingMiddleware Error. Credentials should have been redacted, got %s", logs.String())
+	}
+}
+
+func Test_VerifyCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/oauth2/token":
+			w.Write([]byte(`{"token_type":"bearer","access_token":"token"}`))
+		case "/1.1/application/rate_limit_status.json":
+			if r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"errors":[{"code":89,"message":"Invalid or expired token."}]}`))
+				return
+			}
+			w.Write([]byte(`{"resources":{"users":{"/users/:id":{"limit":900,"remaining":899,"reset":1403602426}},"application":{"/application/rate_limit_status":{"limit":180,"remaining":179,"reset":1403602426}}}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	api, err := twitter.NewTwitter(consumerKey, consumerSecret,
+		twitter.WithV1BaseURL(server.URL+"/1.1"),
+		twitter.WithOAuthBaseURL(server.URL),
+	)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	credentials, err := api.VerifyCredentials()
+	if err != nil {
+		t.Fatalf("Twitter API VerifyCredentials Error: %v", err)
+	}
+
+	if len(credentials.RateLimits) != 2 || credentials.RateLimits[1].Endpoint != "/users/:id" || credentials.RateLimits[1].Remaining != 899 {
+		t.Fatalf("Twitter API VerifyCredentials Error. Should have returned the sorted rate limit status, got %v", credentials.RateLimits)
+	}
+
+	if credentials.User != nil {
+		t.Fatalf("Twitter API VerifyCredentials Error. App context clients have no user, got %v", credentials.User)
+	}
+
+	// invalid credentials are rejected
+	api, _ = twitter.NewTwitterWithContext(consumerKey, consumerSecret, accessToken, accessTokenSecret,
+		twitter.WithV1BaseURL(server.URL+"/1.1"),
+	)
+	if _, err := api.VerifyCredentials(); !twitter.IsUnauthorized(err) {
+		t.Fatalf("Twitter API VerifyCredentials Error. Should have been unauthorized, got %v", err)
+	}
+}
+
+func Test_WithCache(t *testing.T) {
+	var hits int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/oauth2/token":
+			w.Write([]byte(`{"token_type":"bearer","access_token":"token"}`))
+		case "/2/users/44142397":
+			hits++
+			w.Write([]byte(`{"data":{"id":"44142397","username":"andefined"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	disk, err := twitter.NewDiskCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("Cache Error: %v", err)
+	}
+
+	for _, cache := range []twitter.Cache{twitter.NewMemoryCache(10), disk} {
+		hits = 0
+		api, err := twitter.NewTwitter(consumerKey, consumerSecret,
+			twitter.WithBaseURL(server.URL+"/2"),
+			twitter.WithOAuthBaseURL(server.URL),
+			twitter.WithCache(cache, twitter.CacheTTL{}),
+		)
+		if err != nil {
+			t.Fatalf("Couldn't create Twitter API HTTP Client")
+		}
+
+		// the same parameters in a different order share the cache entry
+		for _, reversed := range []bool{false, true} {
+			v := url.Values{}
+			if reversed {
+				v.Add("expansions", "pinned_tweet_id")
+				v.Add("user.fields", "id,username")
+			} else {
+				v.Add("user.fields", "id,username")
+				v.Add("expansions", "pinned_tweet_id")
+			}
+
+			var data *twitter.User
+			r := <-api.GetUserByID("44142397", v, twitter.WithRate(time.Hour))
+			if r.Err != nil {
+				t.Fatalf("Twitter API Error: %v", r.Err)
+			}
+
+			b, _ := json.Marshal(r.Page.Data)
+			json.Unmarshal(b, &data)
+
+			if data == nil || data.UserName != "andefined" {
+				t.Fatalf("Twitter API WithCache Error. Should have returned andefined, got %v", data)
+			}
+		}
+
+		if hits != 1 {
+			t.Fatalf("Twitter API WithCache Error. Should have sent 1 request, got %d", hits)
+		}
+	}
+
+	// the least recently used entries are evicted
+	lru := twitter.NewMemoryCache(2)
+	lru.Set("a", []byte("a"), time.Minute)
+	lru.Set("b", []byte("b"), time.Minute)
+	lru.Get("a")
+	lru.Set("c", []byte("c"), time.Minute)
+	if _, ok := lru.Get("b"); ok || lru.Len() != 2 {
+		t.Fatalf("MemoryCache Error. Should have evicted b")
+	}
+
+	// expired entries are missing
+	lru.Set("d", []byte("d"), -time.Minute)
+	if _, ok := lru.Get("d"); ok {
+		t.Fatalf("MemoryCache Error. Should have expired d")
+	}
+}
+
+func Test_Coalescing(t *testing.T) {
+	var mu sync.Mutex
+	var lookups []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/oauth2/token":
+			w.Write([]byte(`{"token_type":"bearer","access_token":"token"}`))
+		case "/2/users/44142397":
+			mu.Lock()
+			lookups = append(lookups, r.URL.Path)
+			mu.Unlock()
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte(`{"data":{"id":"44142397","username":"andefined"}}`))
+		case "/2/users":
+			mu.Lock()
+			lookups = append(lookups, r.URL.Query().Get("ids"))
+			mu.Unlock()
+			w.Write([]byte(`{"data":[{"id":"1","username":"one"},{"id":"2","username":"two"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	lookup := func(api *twitter.Twitter, id string) (*twitter.User, error) {
+		var data *twitter.User
+		r := <-api.GetUserByID(id, url.Values{}, twitter.WithRate(time.Millisecond))
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		b, _ := json.Marshal(r.Page.Data)
+		json.Unmarshal(b, &data)
+		return data, nil
+	}
+
+	// identical requests share a single call
+	api, err := twitter.NewTwitter(consumerKey, consumerSecret,
+		twitter.WithBaseURL(server.URL+"/2"),
+		twitter.WithOAuthBaseURL(server.URL),
+	)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if data, err := lookup(api, "44142397"); err != nil || data == nil || data.UserName != "andefined" {
+				t.Errorf("Twitter API Coalescing Error. Should have returned andefined, got %v, %v", data, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(lookups) != 1 {
+		t.Fatalf("Twitter API Coalescing Error. Should have sent 1 request, got %v", lookups)
+	}
+
+	// single id lookups are merged into a batched lookup
+	lookups = nil
+	api, _ = twitter.NewTwitter(consumerKey, consumerSecret,
+		twitter.WithBaseURL(server.URL+"/2"),
+		twitter.WithOAuthBaseURL(server.URL),
+		twitter.WithBatching(50*time.Millisecond),
+	)
+
+	users := make(map[string]*twitter.User)
+	errs := make(map[string]error)
+	for _, id := range []string{"1", "2", "3"} {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			data, err := lookup(api, id)
+			mu.Lock()
+			users[id], errs[id] = data, err
+			mu.Unlock()
+		}(id)
+	}
+	wg.Wait()
+
+	if len(lookups) != 1 || len(strings.Split(lookups[0], ",")) != 3 {
+		t.Fatalf("Twitter API Batching Error. Should have sent 1 lookup of 3 ids, got %v", lookups)
+	}
+
+	if users["1"] == nil || users["1"].UserName != "one" || users["2"] == nil || users["2"].UserName != "two" {
+		t.Fatalf("Twitter API Batching Error. Should have fanned out the results, got %v", users)
+	}
+
+	if !twitter.IsNotFound(errs["3"]) {
+		t.Fatalf("Twitter API Batching Error. Missing ids should not be found, got %v", errs["3"])
+	}
+}
+
+func Test_Batching_MaxBatchSize(t *testing.T) {
+	server := twittertest.NewServer()
+	defer server.Close()
+
+	for i := 1; i <= 1000; i++ {
+		server.AddUsers(&twitter.User{ID: fmt.Sprint(i), UserName: fmt.Sprint("user", i)})
+	}
+
+	var mu sync.Mutex
+	var lookups []int
+	capture := twitter.CaptureMiddleware(func(req *twitter.Request, resp *http.Response, body []byte) {
+		if req.Endpoint == "/users" {
+			mu.Lock()
+			lookups = append(lookups, len(strings.Split(req.Req.URL.Query().Get("ids"), ",")))
+			mu.Unlock()
+		}
+	})
+
+	api, err := twitter.NewTwitter(consumerKey, consumerSecret,
+		append(server.ClientOptions(), twitter.WithBatching(50*time.Millisecond), twitter.WithMiddleware(capture))...,
+	)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	// interleave the callers, even on a single cpu
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 1000; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if r := <-api.GetUserByID(id, url.Values{}, twitter.WithRate(time.Millisecond)); r.Err != nil {
+				t.Errorf("Twitter API Error: %v", r.Err)
+			}
+		}(fmt.Sprint(i))
+	}
+	wg.Wait()
+
+	ids := 0
+	for _, n := range lookups {
+		if n > 100 {
+			t.Fatalf("Twitter API Batching Error. Should have sent up to 100 ids per lookup, got %v", lookups)
+		}
+		ids += n
+	}
+	if ids != 1000 {
+		t.Fatalf("Twitter API Batching Error. Should have looked up 1000 ids, got %v", lookups)
+	}
+}
+
+func Test_Batching_WithClock(t *testing.T) {
+	server := twittertest.NewServer()
+	defer server.Close()
+
+	server.AddUsers(&twitter.User{ID: "1", UserName: "andefined"}, &twitter.User{ID: "2", UserName: "cvcio"})
+
+	var mu sync.Mutex
+	var lookups []string
+	capture := twitter.CaptureMiddleware(func(req *twitter.Request, resp *http.Response, body []byte) {
+		mu.Lock()
+		lookups = append(lookups, req.Req.URL.Query().Get("ids"))
+		mu.Unlock()
+	})
+
+	api, err := twitter.NewTwitter(consumerKey, consumerSecret,
+		append(server.ClientOptions(), twitter.WithBatching(time.Second), twitter.WithMiddleware(capture))...,
+	)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	clock := twittertest.NewClock(time.Now())
+
+	// the paused queue doesn't join the batch
+	var paused *twitter.Queue
+	a := api.GetUserByID("1", url.Values{}, twitter.WithClock(clock), twitter.WithQueueHook(func(q *twitter.Queue) {
+		paused = q
+		q.Pause()
+	}))
+	b := api.GetUserByID("2", url.Values{}, twitter.WithClock(clock))
+
+	// the window is timed by the queue's clock
+	clock.BlockUntil(1)
+	select {
+	case r := <-b:
+		t.Fatalf("Twitter API Batching Error. Should have waited for the window, got %v", r)
+	default:
+	}
+	clock.Advance(time.Second)
+	if r := <-b; r.Err != nil {
+		t.Fatalf("Twitter API Error: %v", r.Err)
+	}
+
+	// until it's resumed
+	paused.Resume()
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	if r := <-a; r.Err != nil {
+		t.Fatalf("Twitter API Error: %v", r.Err)
+	}
+
+	if len(lookups) != 2 || lookups[0] != "2" || lookups[1] != "1" {
+		t.Fatalf("Twitter API Batching Error. Should have sent a lookup per window, got %v", lookups)
+	}
+}
+
+func Test_Paginator(t *testing.T) {
+	server := twittertest.NewServer()
+	defer server.Close()
+
+	server.AddUsers(&twitter.User{ID: "1", UserName: "andefined"})
+	for i := 2; i <= 26; i++ {
+		server.AddTweets(&twitter.Tweet{ID: fmt.Sprint(i), AuthorID: "1", Text: "tweet"})
+	}
+
+	api, err := twitter.NewTwitter(consumerKey, consumerSecret, server.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	pages := api.GetUserTweetsPaginator("1", url.Values{"max_results": {"10"}})
+	defer pages.Close()
+
+	count := 0
+	for pages.HasMore() {
+		page, err := pages.Next(context.Background())
+		if err != nil {
+			t.Fatalf("Twitter API Error: %v", err)
+		}
+		count += page.Meta.ResultCount
+
+		if count < 25 && pages.NextToken() == "" {
+			t.Fatalf("Twitter API Paginator Error. Should have returned the next token after %d tweets", count)
+		}
+	}
+
+	if count != 25 {
+		t.Fatalf("Twitter API Paginator Error. Should have returned 25 tweets, got %d", count)
+	}
+
+	if _, err := pages.Next(context.Background()); err != twitter.ErrNoMorePages {
+		t.Fatalf("Twitter API Paginator Error. Should have returned ErrNoMorePages, got %v", err)
+	}
+
+	// stop early
+	pages = api.GetUserTweetsPaginator("1", url.Values{"max_results": {"10"}})
+	pages.Next(context.Background())
+	pages.Close()
+	if pages.HasMore() {
+		t.Fatalf("Twitter API Paginator Error. Should have no more pages once closed")
+	}
+}
+
+func Test_GetTweetsSearchRecent_Pagination(t *testing.T) {
+	server := twittertest.NewServer()
+	defer server.Close()
+
+	for i := 1; i <= 35; i++ {
+		server.AddTweets(&twitter.Tweet{ID: fmt.Sprint(i), Text: "Hello Greece"})
+	}
+	server.AddTweets(&twitter.Tweet{ID: "36", Text: "Hello world"})
+
+	api, err := twitter.NewTwitter(consumerKey, consumerSecret, server.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	for _, c := range []struct{ max, pages, results int }{{0, 4, 35}, {25, 3, 25}} {
+		v := url.Values{"query": {"greece"}, "max_results": {"10"}}
+		res := api.GetTweetsSearchRecent(v, twitter.WithMaxResults(c.max))
+
+		pages, results, next := 0, 0, ""
+		for r := range res {
+			if r.Err != nil {
+				t.Fatalf("Twitter API Error: %v", r.Err)
+			}
+			if r.PageIndex != pages {
+				t.Fatalf("Twitter API GetTweetsSearchRecent Error. Should have returned page %d, got %d", pages, r.PageIndex)
+			}
+			pages++
+			results += r.Page.Meta.ResultCount
+			next = r.NextToken
+		}
+
+		if next != "" {
+			t.Fatalf("Twitter API GetTweetsSearchRecent Error. The last page should have no next token, got %s", next)
+		}
+
+		if pages != c.pages || results != c.results {
+			t.Fatalf("Twitter API GetTweetsSearchRecent Error. Should have returned %d tweets in %d pages, got %d in %d", c.results, c.pages, results, pages)
+		}
+	}
+}
+
+func Test_WithCheckpoint(t *testing.T) {
+	server := twittertest.NewServer()
+	defer server.Close()
+
+	server.AddUsers(&twitter.User{ID: "1", UserName: "andefined"})
+	for i := 2; i <= 251; i++ {
+		server.AddUsers(&twitter.User{ID: fmt.Sprint(i), UserName: fmt.Sprintf("user%d", i)})
+		server.Follow(fmt.Sprint(i), "1")
+	}
+
+	api, err := twitter.NewTwitter(consumerKey, consumerSecret, server.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	store := twitter.NewMemoryCheckpointStore()
+	seen := make(map[string]bool)
+	collect := func(r twitter.Result) {
+		if r.Err != nil {
+			t.Fatalf("Twitter API Error: %v", r.Err)
+		}
+		var users []*twitter.User
+		b, _ := json.Marshal(r.Page.Data)
+		json.Unmarshal(b, &users)
+		for _, u := range users {
+			if seen[u.ID] {
+				t.Fatalf("Twitter API WithCheckpoint Error. Follower %s was returned twice", u.ID)
+			}
+			seen[u.ID] = true
+		}
+	}
+
+	// stop the crawl after the first page
+	ctx, cancel := context.WithCancel(context.Background())
+	res := api.GetUserFollowers("1", url.Values{"max_results": {"100"}},
+		twitter.WithCheckpoint(store, "followers:1"), twitter.WithContext(ctx))
+	collect(<-res)
+	cancel()
+	for range res {
+	}
+
+	checkpoint, err := store.Load("followers:1")
+	if err != nil || checkpoint.Pages != 1 || checkpoint.NextToken == "" {
+		t.Fatalf("Twitter API WithCheckpoint Error. Should have saved the first page, got %v, %v", checkpoint, err)
+	}
+
+	// the checkpoint of a different request is rejected
+	for _, c := range []struct {
+		id string
+		v  url.Values
+	}{{"2", url.Values{"max_results": {"100"}}}, {"1", url.Values{"max_results": {"10"}}}} {
+		r := <-api.GetUserFollowers(c.id, c.v, twitter.WithCheckpoint(store, "followers:1"))
+		if r.Err != twitter.ErrCheckpointMismatch {
+			t.Fatalf("Twitter API WithCheckpoint Error. Should have returned ErrCheckpointMismatch, got %v", r.Err)
+		}
+	}
+
+	// resume the crawl
+	res = api.GetUserFollowers("1", url.Values{"max_results": {"100"}}, twitter.WithCheckpoint(store, "followers:1"))
+	for r := range res {
+		collect(r)
+	}
+
+	if len(seen) != 250 {
+		t.Fatalf("Twitter API WithCheckpoint Error. Should have returned 250 followers, got %d", len(seen))
+	}
+
+	if _, err := store.Load("followers:1"); err != twitter.ErrCheckpointNotFound {
+		t.Fatalf("Twitter API WithCheckpoint Error. Should have deleted the checkpoint, got %v", err)
+	}
+}
+
+func Test_Caps(t *testing.T) {
+	server := twittertest.NewServer()
+	defer server.Close()
+
+	// a tweet per hour, the most recent one first
+	now := time.Now().UTC()
+	server.AddUsers(&twitter.User{ID: "1", UserName: "andefined"})
+	for i := 50; i >= 1; i-- {
+		created := now.Add(-time.Duration(i) * time.Hour).Format(time.RFC3339)
+		server.AddTweets(&twitter.Tweet{ID: fmt.Sprint(i), AuthorID: "1", Text: "tweet", CreatedAt: created})
+	}
+
+	api, err := twitter.NewTwitter(consumerKey, consumerSecret, server.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	cases := []struct {
+		name    string
+		option  twitter.QueueOption
+		pages   int
+		results int
+	}{
+		{"WithMaxPages", twitter.WithMaxPages(2), 2, 20},
+		{"WithMaxResults", twitter.WithMaxResults(15), 2, 15},
+		{"WithUntil", twitter.WithUntil(now.Add(-25*time.Hour - time.Minute)), 3, 25},
+	}
+
+	for _, c := range cases {
+		res := api.GetUserTweets("1", url.Values{"max_results": {"10"}}, c.option)
+
+		pages, results := 0, 0
+		for r := range res {
+			if r.Err != nil {
+				t.Fatalf("Twitter API Error: %v", r.Err)
+			}
+
+			var tweets []*twitter.Tweet
+			b, _ := json.Marshal(r.Page.Data)
+			json.Unmarshal(b, &tweets)
+
+			pages++
+			results += len(tweets)
+		}
+
+		if pages != c.pages || results != c.results {
+			t.Fatalf("Twitter API %s Error. Should have returned %d tweets in %d pages, got %d in %d", c.name, c.results, c.pages, results, pages)
+		}
+	}
+
+	// stop a crawl after 20 tweets
+	store := twitter.NewMemoryCheckpointStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	res := api.GetUserTweets("1", url.Values{"max_results": {"10"}}, twitter.WithCheckpoint(store, "tweets:1"), twitter.WithContext(ctx))
+	<-res
+	<-res
+	cancel()
+	for range res {
+	}
+
+	// resuming it with a lower cap ends the job at once
+	res = api.GetUserTweets("1", url.Values{"max_results": {"10"}}, twitter.WithCheckpoint(store, "tweets:1"), twitter.WithMaxResults(15))
+	for r := range res {
+		t.Fatalf("Twitter API WithMaxResults Error. Should have ended the resumed crawl, got %v", r)
+	}
+	if _, err := store.Load("tweets:1"); err != twitter.ErrCheckpointNotFound {
+		t.Fatalf("Twitter API WithMaxResults Error. Should have deleted the checkpoint, got %v", err)
+	}
+}
+
+func Test_NewTwitterWithPKCE(t *testing.T) {
+	var challenge string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/2/oauth2/token":
+			r.ParseForm()
+			sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+			if r.PostForm.Get("code") != "code" || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"invalid_request"}`))
+				return
+			}
+			w.Write([]byte(`{"token_type":"bearer","access_token":"token","refresh_token":"refresh","expires_in":7200}`))
+		case "/2/users/44142397":
+			if r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"data":{"id":"44142397","username":"andefined"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	config := &twitter.PKCEConfig{
+		ClientID:    "client-id",
+		RedirectURL: "http://127.0.0.1:0/callback",
+		Scopes:      []string{twitter.ScopeTweetRead, twitter.ScopeUsersRead, twitter.ScopeBookmarkRead},
+		// act as the user's browser, authorizing the app
+		OnAuthCodeURL: func(authURL string) error {
+			u, err := url.Parse(authURL)
+			if err != nil {
+				return err
+			}
+			q := u.Query()
+			if q.Get("code_challenge_method") != "S256" {
+				t.Fatalf("Twitter API PKCE Error. Should have used S256, got %s", q.Get("code_challenge_method"))
+			}
+			challenge = q.Get("code_challenge")
+
+			resp, err := http.Get(q.Get("redirect_uri") + "?code=code&state=" + q.Get("state"))
+			if err != nil {
+				return err
+			}
+			return resp.Body.Close()
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	api, err := twitter.NewTwitterWithPKCE(ctx, config,
+		twitter.WithBaseURL(server.URL+"/2"),
+		twitter.WithOAuthBaseURL(server.URL),
+	)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client: %v", err)
+	}
+
+	var data *twitter.User
+	res := api.GetUserByID("44142397", url.Values{})
+	for r := range res {
+		if r.Err != nil {
+			t.Fatalf("Twitter API Error: %v", r.Err)
+		}
+
+		b, err := json.Marshal(r.Page.Data)
+		if err != nil {
+			t.Fatalf("json Marshal Error: %v", err)
+		}
+
+		json.Unmarshal(b, &data)
+	}
+
+	if data == nil || data.UserName != "andefined" {
+		t.Fatalf("Twitter API GetUserByID Error. Should have returned andefined, got %v", data)
+	}
+}
+
+func Test_NewTwitterWithSignIn(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth/request_token":
+			w.Write([]byte("oauth_token=request-token&oauth_token_secret=request-secret&oauth_callback_confirmed=true"))
+		case "/oauth/access_token":
+			if !strings.Contains(r.Header.Get("Authorization"), `oauth_verifier="verifier"`) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("oauth_token=access-token&oauth_token_secret=access-secret&user_id=44142397&screen_name=andefined"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	// pick a free port for the callback
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net Listen Error: %v", err)
+	}
+	callbackURL := "http://" + listener.Addr().String() + "/callback"
+	listener.Close()
+
+	configs := map[string]*twitter.OAuth1Config{
+		"callback": {
+			ConsumerKey:    "consumer-key",
+			ConsumerSecret: "consumer-secret",
+			CallbackURL:    callbackURL,
+			// act as the user's browser, authorizing the app
+			OnAuthorizeURL: func(authorizeURL string) error {
+				u, _ := url.Parse(authorizeURL)
+				resp, err := http.Get(callbackURL + "?oauth_token=" + u.Query().Get("oauth_token") + "&oauth_verifier=verifier")
+				if err != nil {
+					return err
+				}
+				return resp.Body.Close()
+			},
+		},
+		"pin": {
+			ConsumerKey:    "consumer-key",
+			ConsumerSecret: "consumer-secret",
+			OnAuthorizeURL: func(string) error { return nil },
+			OnPIN:          func() (string, error) { return "verifier", nil },
+		},
+	}
+
+	for name, config := range configs {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		api, token, err := twitter.NewTwitterWithSignIn(ctx, config, twitter.WithOAuthBaseURL(server.URL))
+		cancel()
+		if err != nil {
+			t.Fatalf("Twitter API NewTwitterWithSignIn (%s) Error: %v", name, err)
+		}
+
+		if api.GetClient() == nil {
+			t.Fatalf("Twitter API HTTP Client returned nil")
+		}
+
+		if token.Token != "access-token" || token.Secret != "access-secret" || token.ScreenName != "andefined" {
+			t.Fatalf("Twitter API NewTwitterWithSignIn (%s) Error. Should have returned the access token, got %v", name, token)
+		}
+	}
+}
+
+func Test_FileTokenStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "twitter")
+	if err != nil {
+		t.Fatalf("ioutil TempDir Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := twitter.NewFileTokenStore(filepath.Join(dir, "token.json"), []byte("passphrase")); err == nil {
+		t.Fatalf("FileTokenStore Error. Should have rejected a key that isn't 32 bytes")
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("rand Read Error: %v", err)
+	}
+
+	path := filepath.Join(dir, "token.json")
+	store, err := twitter.NewFileTokenStore(path, key)
+	if err != nil {
+		t.Fatalf("FileTokenStore Error: %v", err)
+	}
+
+	if _, err := store.Load(); !errors.Is(err, twitter.ErrTokenNotFound) {
+		t.Fatalf("FileTokenStore Load Error. Should have returned ErrTokenNotFound, got %v", err)
+	}
+
+	token := &twitter.Token{AccessToken: "token", RefreshToken: "refresh", TokenType: "bearer"}
+	if err := store.Save(token); err != nil {
+		t.Fatalf("FileTokenStore Save Error: %v", err)
+	}
+
+	b, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(b), "refresh") {
+		t.Fatalf("FileTokenStore Save Error. Should have encrypted the token, got %s", b)
+	}
+
+	wrong, _ := twitter.NewFileTokenStore(path, make([]byte, 32))
+	if _, err := wrong.Load(); err == nil {
+		t.Fatalf("FileTokenStore Load Error. Should have failed with the wrong key")
+	}
+
+	// a stored token skips the authorization flow
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"data":{"id":"44142397","username":"andefined"}}`))
+	}))
+	defer server.Close()
+
+	api, err := twitter.NewTwitterWithPKCE(context.Background(), &twitter.PKCEConfig{
+		ClientID: "client-id",
+		OnAuthCodeURL: func(string) error {
+			return errors.New("should have used the stored token")
+		},
+	}, twitter.WithBaseURL(server.URL), twitter.WithTokenStore(store))
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client: %v", err)
+	}
+
+	if r := <-api.GetUserByID("44142397", url.Values{}); r.Err != nil {
+		t.Fatalf("Twitter API Error: %v", r.Err)
+	}
+}
+
+// saveErrorTokenStore fails to save tokens with err, if set.
+type saveErrorTokenStore struct {
+	twitter.MemoryTokenStore
+	err error
+}
+
+func (s *saveErrorTokenStore) Save(token *twitter.Token) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.MemoryTokenStore.Save(token)
+}
+
+func Test_TokenStore_SaveError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/2/oauth2/token":
+			r.ParseForm()
+			if r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("refresh_token") != "refresh" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"invalid_request"}`))
+				return
+			}
+			w.Write([]byte(`{"token_type":"bearer","access_token":"refreshed","refresh_token":"rotated","expires_in":7200}`))
+		case "/2/users/44142397":
+			if r.Header.Get("Authorization") != "Bearer refreshed" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"data":{"id":"44142397","username":"andefined"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	// an expired token is refreshed on the first request
+	store := &saveErrorTokenStore{err: errors.New("disk full")}
+	store.MemoryTokenStore.Save(&twitter.Token{AccessToken: "token", RefreshToken: "refresh", TokenType: "bearer", Expiry: time.Now().Add(-time.Hour)})
+
+	api, err := twitter.NewTwitterWithPKCE(context.Background(), &twitter.PKCEConfig{ClientID: "client-id"},
+		twitter.WithBaseURL(server.URL+"/2"),
+		twitter.WithOAuthBaseURL(server.URL),
+		twitter.WithTokenStore(store),
+	)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client: %v", err)
+	}
+
+	// the rotated refresh token can't be persisted, so the request fails
+	r := <-api.GetUserByID("44142397", url.Values{}, twitter.WithAuto(false))
+	if r.Err == nil || !strings.Contains(r.Err.Error(), "disk full") {
+		t.Fatalf("Twitter API TokenStore Error. Should have returned the save error, got %v", r.Err)
+	}
+
+	// and the save is retried on the next request
+	store.err = nil
+	if r := <-api.GetUserByID("44142397", url.Values{}, twitter.WithAuto(false)); r.Err != nil {
+		t.Fatalf("Twitter API Error: %v", r.Err)
+	}
+
+	if token, _ := store.Load(); token == nil || token.RefreshToken != "rotated" {
+		t.Fatalf("Twitter API TokenStore Error. Should have saved the refreshed token, got %v", token)
+	}
+}
+
+func Test_LookupUsers(t *testing.T) {
+	server := twittertest.NewServer()
+	defer server.Close()
+
+	var ids []string
+	for i := 1; i <= 250; i++ {
+		if i%50 != 0 {
+			server.AddUsers(&twitter.User{ID: fmt.Sprint(i), UserName: fmt.Sprintf("user%d", i)})
+		}
+		ids = append(ids, fmt.Sprint(i))
+	}
+	// duplicates are looked up once
+	ids = append(ids, "1", "2", "3")
+
+	api, err := twitter.NewTwitter(consumerKey, consumerSecret, server.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	lookup, err := api.LookupUsers(ids, url.Values{}, twitter.WithRate(time.Millisecond))
+	if err != nil {
+		t.Fatalf("Twitter API LookupUsers Error: %v", err)
+	}
+	if len(lookup.Users) != 245 {
+		t.Fatalf("Twitter API LookupUsers Error. Should have returned 245 users, got %d", len(lookup.Users))
+	}
+	if len(lookup.Errors) != 5 || lookup.Errors["100"] == nil || lookup.Errors["100"].Title != "Not Found Error" {
+		t.Fatalf("Twitter API LookupUsers Error. Should have reported 5 missing ids, got %v", lookup.Errors)
+	}
+
+	// usernames are deduplicated case-insensitively
+	lookup, err = api.LookupUsersByUserName([]string{"user1", "USER1", "user2", "user50"}, url.Values{}, twitter.WithRate(time.Millisecond))
+	if err != nil {
+		t.Fatalf("Twitter API LookupUsersByUserName Error: %v", err)
+	}
+	if len(lookup.Users) != 2 || len(lookup.Errors) != 1 || lookup.Errors["user50"] == nil {
+		t.Fatalf("Twitter API LookupUsersByUserName Error. Should have returned 2 users and 1 error, got %d, %v", len(lookup.Users), lookup.Errors)
+	}
+
+	// malformed results are reported
+	malformed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/oauth2/token":
+			w.Write([]byte(`{"token_type":"bearer","access_token":"token"}`))
+		default:
+			w.Write([]byte(`{"data":[{"id":1,"username":"andefined"}]}`))
+		}
+	}))
+	defer malformed.Close()
+
+	api, _ = twitter.NewTwitter(consumerKey, consumerSecret,
+		twitter.WithBaseURL(malformed.URL+"/2"),
+		twitter.WithOAuthBaseURL(malformed.URL),
+	)
+	var e *json.UnmarshalTypeError
+	if _, err := api.LookupUsers([]string{"1"}, url.Values{}); !errors.As(err, &e) {
+		t.Fatalf("Twitter API LookupUsers Error. Should have returned the decoding error, got %v", err)
+	}
+}
+
+func Test_LookupTweets(t *testing.T) {
+	// every chunk includes the same two media
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/oauth2/token":
+			w.Write([]byte(`{"token_type":"bearer","access_token":"token"}`))
+		default:
+			ids := strings.Split(r.URL.Query().Get("ids"), ",")
+			w.Write([]byte(fmt.Sprintf(`{"data":[{"id":"%s","text":"tweet"}],"includes":{"media":[`, ids[0]) +
+				`{"media_key":"3_1","type":"photo","url":"https://pbs.twimg.com/media/1.jpg"},` +
+				`{"media_key":"7_2","type":"video","duration_ms":1500,"preview_image_url":"https://pbs.twimg.com/media/2.jpg"}]}}`))
+		}
+	}))
+	defer server.Close()
+
+	api, err := twitter.NewTwitter(consumerKey, consumerSecret,
+		twitter.WithBaseURL(server.URL+"/2"),
+		twitter.WithOAuthBaseURL(server.URL),
+	)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	var ids []string
+	for i := 1; i <= 150; i++ {
+		ids = append(ids, fmt.Sprint(i))
+	}
+	lookup, err := api.LookupTweets(ids, url.Values{}, twitter.WithRate(time.Millisecond))
+	if err != nil {
+		t.Fatalf("Twitter API LookupTweets Error: %v", err)
+	}
+	if len(lookup.Tweets) != 2 {
+		t.Fatalf("Twitter API LookupTweets Error. Should have returned a tweet per chunk, got %d", len(lookup.Tweets))
+	}
+	if len(lookup.Includes.Media) != 2 {
+		t.Fatalf("Twitter API LookupTweets Error. Should have merged 2 media, got %d", len(lookup.Includes.Media))
+	}
+	if m := lookup.Includes.Media[1]; m.MediaKey != "7_2" || m.DurationMS != 1500 || m.PreviewImageURL == "" {
+		t.Fatalf("Twitter API LookupTweets Error. Should have decoded the media, got %+v", m)
+	}
+}
+
+func Test_JobQueue(t *testing.T) {
+	server := twittertest.NewServer()
+	defer server.Close()
+
+	server.AddUsers(&twitter.User{ID: "1", UserName: "andefined"}, &twitter.User{ID: "2", UserName: "atsipras"})
+	for i := 3; i <= 252; i++ {
+		server.AddUsers(&twitter.User{ID: fmt.Sprint(i), UserName: fmt.Sprintf("user%d", i)})
+		server.Follow(fmt.Sprint(i), "1")
+		if i%2 == 0 {
+			server.Follow(fmt.Sprint(i), "2")
+		}
+	}
+
+	api, err := twitter.NewTwitter(consumerKey, consumerSecret, server.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	path := filepath.Join(t.TempDir(), "jobs.log")
+	jobs := []*twitter.Job{
+		{Kind: twitter.JobUserFollowers, UserID: "1", Params: url.Values{"max_results": {"100"}}},
+		{Kind: twitter.JobUserFollowers, UserID: "2", Params: url.Values{"max_results": {"100"}}},
+	}
+
+	followers := make(map[string]map[string]bool)
+	delivered := make(map[string]int)
+	handler := func(ctx context.Context, page *twitter.JobPage) error {
+		delivered[fmt.Sprintf("%s/%d", page.Job.ID, page.Index)]++
+		// crash on the second page of the first job, the first time
+		if page.Job.UserID == "1" && page.Index == 1 && delivered["followers:1/1"] == 1 {
+			return errors.New("crash")
+		}
+
+		var users []*twitter.User
+		b, _ := json.Marshal(page.Data.Data)
+		json.Unmarshal(b, &users)
+		if followers[page.Job.UserID] == nil {
+			followers[page.Job.UserID] = make(map[string]bool)
+		}
+		for _, u := range users {
+			followers[page.Job.UserID][u.ID] = true
+		}
+		return nil
+	}
+
+	queue, err := twitter.OpenJobQueue(path)
+	if err != nil {
+		t.Fatalf("Twitter API OpenJobQueue Error: %v", err)
+	}
+	if err := queue.Add(jobs...); err != nil {
+		t.Fatalf("Twitter API JobQueue Error: %v", err)
+	}
+	if err := queue.Run(context.Background(), api, handler); err == nil || err.Error() != "crash" {
+		t.Fatalf("Twitter API JobQueue Error. Should have stopped with the handler's error, got %v", err)
+	}
+	queue.Close()
+
+	// a partial record, from a crash while appending
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	f.WriteString(`{"op":"page","id":"followers:1"`)
+	f.Close()
+
+	// restart, adding the same jobs again
+	queue, err = twitter.OpenJobQueue(path)
+	if err != nil {
+		t.Fatalf("Twitter API OpenJobQueue Error: %v", err)
+	}
+	defer queue.Close()
+	if err := queue.Add(jobs...); err != nil {
+		t.Fatalf("Twitter API JobQueue Error: %v", err)
+	}
+	if err := queue.Run(context.Background(), api, handler); err != nil {
+		t.Fatalf("Twitter API JobQueue Error: %v", err)
+	}
+
+	if delivered["followers:1/0"] != 1 || delivered["followers:1/1"] != 2 || delivered["followers:1/2"] != 1 {
+		t.Fatalf("Twitter API JobQueue Error. Should have delivered the crashed page again, got %v", delivered)
+	}
+	if len(followers["1"]) != 250 || len(followers["2"]) != 125 {
+		t.Fatalf("Twitter API JobQueue Error. Should have returned 250 and 125 followers, got %d and %d", len(followers["1"]), len(followers["2"]))
+	}
+
+	for _, status := range queue.Jobs() {
+		if status.State != twitter.JobDone {
+			t.Fatalf("Twitter API JobQueue Error. Job %s should be done, got %s", status.Job.ID, status.State)
+		}
+	}
+	if err := queue.Compact(); err != nil {
+		t.Fatalf("Twitter API JobQueue Compact Error: %v", err)
+	}
+
+	compacted, err := twitter.OpenJobQueue(path)
+	if err != nil {
+		t.Fatalf("Twitter API OpenJobQueue Error: %v", err)
+	}
+	defer compacted.Close()
+	if status := compacted.Jobs()[0]; status.State != twitter.JobDone || status.Pages != 3 || status.Results != 250 {
+		t.Fatalf("Twitter API JobQueue Compact Error. Should have kept the progress of the job, got %+v", status)
+	}
+}
+
+func Test_JobQueue_Errors(t *testing.T) {
+	server := twittertest.NewServer()
+	defer server.Close()
+
+	server.AddUsers(&twitter.User{ID: "1", UserName: "andefined"}, &twitter.User{ID: "2", UserName: "cvcio"})
+	server.Follow("2", "1")
+	// more server errors than the retries of the queue
+	server.Fail("GET /users/:id/followers", 503, 5)
+
+	api, err := twitter.NewTwitter(consumerKey, consumerSecret, server.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	queue, err := twitter.OpenJobQueue(filepath.Join(t.TempDir(), "jobs.log"))
+	if err != nil {
+		t.Fatalf("Twitter API OpenJobQueue Error: %v", err)
+	}
+	defer queue.Close()
+	queue.Add(&twitter.Job{Kind: twitter.JobUserFollowers, UserID: "1"})
+
+	handler := func(ctx context.Context, page *twitter.JobPage) error { return nil }
+	options := []twitter.QueueOption{twitter.WithRate(time.Millisecond), twitter.WithDelay(time.Millisecond)}
+
+	// a transient error stops the run, leaving the job pending
+	var apiErr *twitter.APIError
+	if err := queue.Run(context.Background(), api, handler, options...); !errors.As(err, &apiErr) || apiErr.StatusCode != 503 {
+		t.Fatalf("Twitter API JobQueue Error. Should have returned the server error, got %v", err)
+	}
+	if status := queue.Jobs()[0]; status.State != twitter.JobPending {
+		t.Fatalf("Twitter API JobQueue Error. Should have left the job pending, got %s", status.State)
+	}
+
+	// until the next run
+	if err := queue.Run(context.Background(), api, handler, options...); err != nil {
+		t.Fatalf("Twitter API JobQueue Error: %v", err)
+	}
+	if status := queue.Jobs()[0]; status.State != twitter.JobDone {
+		t.Fatalf("Twitter API JobQueue Error. Should have completed the job, got %s", status.State)
+	}
+
+	// an error that isn't retryable fails the job, and the rest of the jobs continue
+	server.Fail("GET /users/:id/followers", 400, 1)
+	queue.Add(&twitter.Job{Kind: twitter.JobUserFollowers, UserID: "2"}, &twitter.Job{Kind: twitter.JobUserFollowing, UserID: "2"})
+	if err := queue.Run(context.Background(), api, handler, options...); err != nil {
+		t.Fatalf("Twitter API JobQueue Error: %v", err)
+	}
+	if statuses := queue.Jobs(); statuses[1].State != twitter.JobFailed || statuses[2].State != twitter.JobDone {
+		t.Fatalf("Twitter API JobQueue Error. Should have failed only the first job, got %s and %s", statuses[1].State, statuses[2].State)
+	}
+}
+
+func Test_QueueControls(t *testing.T) {
+	server := twittertest.NewServer()
+	defer server.Close()
+
+	server.AddUsers(&twitter.User{ID: "1", UserName: "andefined"})
+	for i := 2; i <= 251; i++ {
+		server.AddUsers(&twitter.User{ID: fmt.Sprint(i), UserName: fmt.Sprintf("user%d", i)})
+		server.Follow(fmt.Sprint(i), "1")
+	}
+	server.Fail("GET /users/:id/followers", 503, 1)
+
+	api, err := twitter.NewTwitter(consumerKey, consumerSecret, server.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	// start paused
+	var queue *twitter.Queue
+	policy := twitter.RetryPolicy{MaxAttempts: 2, BaseBackoff: time.Millisecond}
+	res := api.GetUserFollowers("1", url.Values{"max_results": {"100"}}, twitter.WithRetryPolicy(policy),
+		twitter.WithQueueHook(func(q *twitter.Queue) {
+			queue = q
+			q.Pause()
+		}))
+
+	time.Sleep(50 * time.Millisecond)
+	if stats := queue.Stats(); !stats.Paused || stats.Pending != 1 || stats.Sent != 0 {
+		t.Fatalf("Twitter API Queue Error. Should have been paused with a pending request, got %+v", stats)
+	}
+
+	queue.Resume()
+	pages := 0
+	for r := range res {
+		if r.Err != nil {
+			t.Fatalf("Twitter API Error: %v", r.Err)
+		}
+		pages++
+	}
+
+	stats := queue.Stats()
+	if pages != 3 || stats.Pending != 0 || stats.Sent != 4 || stats.Succeeded != 3 || stats.Retried != 1 || stats.Failed != 0 {
+		t.Fatalf("Twitter API Queue Error. Should have sent 4 requests for 3 pages, got %d pages, %+v", pages, stats)
+	}
+	if stats.RateLimit.Limit != twittertest.DefaultRateLimit || stats.Paused {
+		t.Fatalf("Twitter API Queue Error. Should have reported the last rate limit headers, got %+v", stats)
+	}
+
+	// cancel after the first page
+	res = api.GetUserFollowers("1", url.Values{"max_results": {"100"}}, twitter.WithQueueHook(func(q *twitter.Queue) {
+		queue = q
+	}))
+	<-res
+	queue.Cancel()
+	for range res {
+	}
+	if stats := queue.Stats(); !stats.Canceled || stats.Succeeded > 2 {
+		t.Fatalf("Twitter API Queue Error. Should have been canceled, got %+v", stats)
+	}
+}
+
+func Test_WithClock(t *testing.T) {
+	server := twittertest.NewServer()
+	defer server.Close()
+
+	clock := twittertest.NewClock(time.Now())
+	server.SetClock(clock)
+	server.AddUsers(&twitter.User{ID: "1", UserName: "andefined"})
+	server.Fail("GET /users/:id", 429, 1)
+
+	api, err := twitter.NewTwitter(consumerKey, consumerSecret, server.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	// the rate limit error exhausts the budget until the window resets
+	res := api.GetUserByID("1", url.Values{}, twitter.WithClock(clock))
+	clock.BlockUntil(1)
+	select {
+	case r := <-res:
+		t.Fatalf("Twitter API WithClock Error. Should have waited for the window to reset, got %v", r)
+	default:
+	}
+
+	clock.Advance(twittertest.DefaultWindow + time.Second)
+	if r := <-res; r.Err != nil {
+		t.Fatalf("Twitter API Error: %v", r.Err)
+	}
+}
+
+func Test_WithClock_Retry(t *testing.T) {
+	server := twittertest.NewServer()
+	defer server.Close()
+
+	clock := twittertest.NewClock(time.Now())
+	server.SetClock(clock)
+	server.AddUsers(&twitter.User{ID: "1", UserName: "andefined"})
+	server.Fail("GET /users/:id", 503, 2)
+
+	api, err := twitter.NewTwitter(consumerKey, consumerSecret, server.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	// server errors are retried every delay
+	var queue *twitter.Queue
+	res := api.GetUserByID("1", url.Values{}, twitter.WithClock(clock), twitter.WithDelay(15*time.Minute),
+		twitter.WithQueueHook(func(q *twitter.Queue) { queue = q }))
+	for i := 1; i <= 2; i++ {
+		clock.BlockUntil(1)
+		select {
+		case r := <-res:
+			t.Fatalf("Twitter API WithClock Error. Should have backed off before retry %d, got %v", i, r)
+		default:
+		}
+		if stats := queue.Stats(); stats.Retried != i-1 || !stats.NextAllowed.Equal(clock.Now().Add(15*time.Minute)) {
+			t.Fatalf("Twitter API WithClock Error. Should have backed off for 15 minutes before retry %d, got %+v", i, stats)
+		}
+		clock.Advance(15 * time.Minute)
+	}
+
+	if r := <-res; r.Err != nil {
+		t.Fatalf("Twitter API Error: %v", r.Err)
+	}
+	if stats := queue.Stats(); stats.Sent != 3 || stats.Retried != 2 || stats.Succeeded != 1 {
+		t.Fatalf("Twitter API WithClock Error. Should have sent 3 requests with 2 retries, got %+v", stats)
+	}
+}
+
+func Test_RateLimitPacing(t *testing.T) {
+	server := twittertest.NewServer()
+	defer server.Close()
+
+	clock := twittertest.NewClock(time.Now())
+	server.SetClock(clock)
+	server.SetRateLimit("GET /users/:id/tweets", 1, 15*time.Minute)
+	server.AddUsers(&twitter.User{ID: "1", UserName: "andefined"})
+	for i := 2; i <= 21; i++ {
+		server.AddTweets(&twitter.Tweet{ID: fmt.Sprint(i), AuthorID: "1", Text: "tweet"})
+	}
+
+	api, err := twitter.NewTwitter(consumerKey, consumerSecret, server.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	var queue *twitter.Queue
+	res := api.GetUserTweets("1", url.Values{"max_results": {"10"}}, twitter.WithClock(clock), twitter.WithQueueHook(func(q *twitter.Queue) { queue = q }))
+	r := <-res
+	if r.Err != nil {
+		t.Fatalf("Twitter API Error: %v", r.Err)
+	}
+	if r.RateLimit.Remaining != 0 || r.RateLimit.Reset.IsZero() {
+		t.Fatalf("Twitter API RateLimit Error. Should have exhausted the budget, got %+v", r.RateLimit)
+	}
+
+	// the next page waits until x-rate-limit-reset, instead of failing with 429
+	clock.BlockUntil(1)
+	select {
+	case r := <-res:
+		t.Fatalf("Twitter API RateLimit Error. Should have waited for the window to reset, got %v", r)
+	default:
+	}
+
+	clock.Advance(15*time.Minute + time.Second)
+	r = <-res
+	if r.Err != nil {
+		t.Fatalf("Twitter API Error: %v", r.Err)
+	}
+	if r.PageIndex != 1 {
+		t.Fatalf("Twitter API RateLimit Error. Should have returned the second page, got %d", r.PageIndex)
+	}
+	if stats := queue.Stats(); stats.Sent != 2 || stats.Retried != 0 {
+		t.Fatalf("Twitter API RateLimit Error. Should have sent 2 requests without retries, got %+v", stats)
+	}
+}
+
+func Test_SharedRateLimit(t *testing.T) {
+	server := twittertest.NewServer()
+	defer server.Close()
+
+	clock := twittertest.NewClock(time.Now())
+	server.SetClock(clock)
+	server.SetRateLimit("GET /users/:id", 2, 15*time.Minute)
+	server.AddUsers(&twitter.User{ID: "1", UserName: "andefined"}, &twitter.User{ID: "2", UserName: "cvcio"})
+
+	api, err := twitter.NewTwitter(consumerKey, consumerSecret, server.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	// without retries, a 429 would be returned as an error
+	options := []twitter.QueueOption{twitter.WithAuto(false), twitter.WithClock(clock)}
+
+	// the first call learns the budget of the endpoint
+	if r := <-api.GetUserByID("1", url.Values{}, options...); r.Err != nil {
+		t.Fatalf("Twitter API Error: %v", r.Err)
+	}
+
+	// two concurrent calls share the last request of the window
+	a := api.GetUserByID("1", url.Values{}, options...)
+	b := api.GetUserByID("2", url.Values{}, options...)
+
+	var first twitter.Result
+	waiting := a
+	select {
+	case first = <-a:
+		waiting = b
+	case first = <-b:
+	}
+	if first.Err != nil {
+		t.Fatalf("Twitter API Error: %v", first.Err)
+	}
+
+	clock.BlockUntil(1)
+	select {
+	case r := <-waiting:
+		t.Fatalf("Twitter API RateLimit Error. Should have waited for the window to reset, got %v", r)
+	default:
+	}
+
+	clock.Advance(15*time.Minute + time.Second)
+	if r := <-waiting; r.Err != nil {
+		t.Fatalf("Twitter API Error: %v", r.Err)
+	}
+}
+
+func Test_WithStallTimeout(t *testing.T) {
+	server := twittertest.NewServer()
+	defer server.Close()
+
+	api, err := twitter.NewTwitter(consumerKey, consumerSecret, server.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+
+	clock := twittertest.NewClock(time.Now())
+	s, err := api.GetSampleStream(url.Values{}, twitter.WithStreamClock(clock), twitter.WithStallTimeout(90*time.Second))
+	if err != nil {
+		t.Fatalf("Twitter API Error: %v", err)
+	}
+	defer s.Stop()
+
+	// data keeps the stream alive
+	clock.BlockUntil(1)
+	clock.Advance(60 * time.Second)
+	server.Publish(&twitter.Tweet{ID: "1", Text: "Hello Greece"})
+	if d, ok := (<-s.C).(twitter.StreamData); !ok || d.Data == nil || d.Data.ID != "1" {
+		t.Fatalf("Twitter API WithStallTimeout Error. Should have streamed the tweet, got %v", d)
+	}
+	clock.Advance(30 * time.Second)
+	clock.BlockUntil(1)
+
+	// until nothing is received for the timeout
+	clock.Advance(60 * time.Second)
+	if d := <-s.C; d != twitter.ErrStreamStalled {
+		t.Fatalf("Twitter API WithStallTimeout Error. Should have stalled, got %v", d)
+	}
+	if _, ok := <-s.C; ok {
+		t.Fatalf("Twitter API WithStallTimeout Error. Should have closed the stream")
+	}
+
+	// a slow consumer isn't a stall
+	s, err = api.GetSampleStream(url.Values{}, twitter.WithStreamClock(clock), twitter.WithStallTimeout(90*time.Second))
+	if err != nil {
+		t.Fatalf("Twitter API Error: %v", err)
+	}
+	defer s.Stop()
+
+	clock.BlockUntil(1)
+	server.Publish(&twitter.Tweet{ID: "2", Text: "Hello Greece"})
+	time.Sleep(50 * time.Millisecond)
+	clock.Advance(120 * time.Second)
+	time.Sleep(50 * time.Millisecond)
+	if d, ok := (<-s.C).(twitter.StreamData); !ok || d.Data == nil || d.Data.ID != "2" {
+		t.Fatalf("Twitter API WithStallTimeout Error. Should have delivered the tweet, got %v", d)
+
+	}
+}
@@ -0,0 +1,118 @@
+package twittertest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cvcio/twitter"
+)
+
+// Clock is a fake twitter.Clock, whose time only moves with Advance, so that rate limits,
+// retries, backoffs and stall detection can be tested instantly and deterministically.
+//
+//	clock := twittertest.NewClock(time.Now())
+//	res := api.GetUserFollowers("1", url.Values{}, twitter.WithClock(clock))
+//
+//	// wait for the queue to back off, and skip the backoff
+//	clock.BlockUntil(1)
+//	clock.Advance(15 * time.Minute)
+type Clock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers map[*timer]bool
+}
+
+// timer is a timer of the fake clock
+type timer struct {
+	clock *Clock
+	c     chan time.Time
+	when  time.Time
+}
+
+// NewClock returns a new Clock, set to now.
+func NewClock(now time.Time) *Clock {
+	c := &Clock{now: now, timers: make(map[*timer]bool)}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now implements twitter.Clock
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// NewTimer implements twitter.Clock. The timer fires once the clock is advanced past d.
+func (c *Clock) NewTimer(d time.Duration) twitter.Timer {
+	t := &timer{clock: c, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the clock forward by d, firing the timers that are due.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	for t := range c.timers {
+		if !t.when.After(c.now) {
+			c.fire(t)
+		}
+	}
+}
+
+// BlockUntil blocks until at least n timers are waiting to fire,
+// e.g. until the goroutine under test is waiting on the clock.
+func (c *Clock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
+
+// fire sends the time on the timer's channel and removes it. The caller holds the lock.
+func (c *Clock) fire(t *timer) {
+	delete(c.timers, t)
+	select {
+	case t.c <- c.now:
+	default:
+	}
+}
+
+// C implements twitter.Timer
+func (t *timer) C() <-chan time.Time {
+	return t.c
+}
+
+// Stop implements twitter.Timer
+func (t *timer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	active := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	return active
+}
+
+// Reset implements twitter.Timer
+func (t *timer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	active := t.clock.timers[t]
+	t.when = t.clock.now.Add(d)
+	if d <= 0 {
+		t.clock.fire(t)
+		return active
+	}
+
+	t.clock.timers[t] = true
+	t.clock.cond.Broadcast()
+	return active
+}
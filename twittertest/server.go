@@ -0,0 +1,784 @@
+// Package twittertest provides an in-process fake of the Twitter API v2 endpoints called by the
+// twitter package, to integration-test code built on it end to end, without network access.
+//
+// The server is backed by an in-memory dataset, seeded with AddUsers, AddTweets and Follow.
+// It paginates results with opaque tokens, returns the `x-rate-limit-*` headers, enforces
+// the configured rate limits, injects failures with Fail, revokes
+// the credentials of Apps with Revoke and sends heartbeats on streams.
+// Clock is a fake twitter.Clock, to test the timing of the client and the server instantly.
+//
+//	server := twittertest.NewServer()
+//	defer server.Close()
+//
+//	server.AddUsers(&twitter.User{ID: "1", UserName: "andefined"})
+//	api, _ := twitter.NewTwitter("key", "secret", server.ClientOptions()...)
+package twittertest
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cvcio/twitter"
+)
+
+// DefaultRateLimit is the number of requests allowed per window on each endpoint,
+// unless set with SetRateLimit.
+const DefaultRateLimit = 900
+
+// DefaultWindow is the rate limit window of each endpoint, unless set with SetRateLimit.
+const DefaultWindow = 15 * time.Minute
+
+// DefaultHeartbeat is the interval of the keep-alive heartbeats of streams.
+const DefaultHeartbeat = 20 * time.Second
+
+// routes holds the endpoint templates of the server, literal paths first
+var routes = []string{
+	"POST /oauth2/token",
+	"GET /1.1/application/rate_limit_status.json",
+	"GET /2/users",
+	"GET /2/users/by",
+	"GET /2/users/me",
+	"GET /2/users/by/username/:username",
+	"GET /2/users/:id",
+	"GET /2/users/:id/followers",
+	"GET /2/users/:id/following",
+	"GET /2/users/:id/tweets",
+	"GET /2/users/:id/mentions",
+	"GET /2/tweets",
+	"GET /2/tweets/search/recent",
+	"GET /2/tweets/search/all",
+	"GET /2/tweets/search/stream/rules",
+	"POST /2/tweets/search/stream/rules",
+	"GET /2/tweets/search/stream",
+	"GET /2/tweets/sample/stream",
+	"GET /2/tweets/:id",
+}
+
+// Server is a fake Twitter API v2 server.
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	users     []*twitter.User
+	tweets    []*twitter.Tweet
+	followers map[string][]string
+	following map[string][]string
+	me        string
+	rules     []*twitter.RulesData
+	ruleID    int
+	streams   map[chan *twitter.StreamData]bool
+	heartbeat time.Duration
+	clock     twitter.Clock
+	limits    map[string]*limit
+	faults    map[string][]int
+	tokens    map[string]int
+	revoked   map[string]bool
+}
+
+// limit is the rate limit of an endpoint
+type limit struct {
+	limit     int
+	window    time.Duration
+	remaining int
+	reset     time.Time
+}
+
+// NewServer starts and returns a new Server, with an empty dataset.
+// The caller should call Close when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		followers: make(map[string][]string),
+		following: make(map[string][]string),
+		streams:   make(map[chan *twitter.StreamData]bool),
+		heartbeat: DefaultHeartbeat,
+		clock:     twitter.SystemClock,
+		limits:    make(map[string]*limit),
+		faults:    make(map[string][]int),
+		tokens:    make(map[string]int),
+		revoked:   make(map[string]bool),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// ClientOptions returns the options pointing a twitter client to the server.
+func (s *Server) ClientOptions() []twitter.ClientOption {
+	return []twitter.ClientOption{
+		twitter.WithBaseURL(s.URL + "/2"),
+		twitter.WithV1BaseURL(s.URL + "/1.1"),
+		twitter.WithOAuthBaseURL(s.URL),
+	}
+}
+
+// AddUsers adds the users to the dataset.
+func (s *Server) AddUsers(users ...*twitter.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users = append(s.users, users...)
+}
+
+// AddTweets adds the tweets to the dataset, timelines and search results
+// return the most recently added tweets first.
+func (s *Server) AddTweets(tweets ...*twitter.Tweet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tweets = append(s.tweets, tweets...)
+}
+
+// Follow makes the user with followerID follow the user with userID.
+func (s *Server) Follow(followerID, userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.followers[userID] = append(s.followers[userID], followerID)
+	s.following[followerID] = append(s.following[followerID], userID)
+}
+
+// SetMe sets the authenticated user, returned by `/2/users/me`.
+func (s *Server) SetMe(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.me = id
+}
+
+// SetRateLimit sets the number of requests allowed per window on the endpoint,
+// e.g. `GET /users/:id/followers`, resetting its current budget.
+func (s *Server) SetRateLimit(endpoint string, requests int, window time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limits[endpoint] = &limit{limit: requests, window: window, remaining: requests}
+}
+
+// Fail makes the next n requests on the endpoint, e.g. `GET /users/:id/followers`, fail with
+// status. Rate limit errors (429) exhaust the endpoint's budget until its window resets,
+// with a `Retry-After` header, and invalid requests (400) detail their errors.
+func (s *Server) Fail(endpoint string, status int, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		s.faults[endpoint] = append(s.faults[endpoint], status)
+	}
+}
+
+// Revoke rejects the requests of the App with consumerKey, authenticated with
+// its App-only token, with 401 Unauthorized.
+func (s *Server) Revoke(consumerKey string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revoked[consumerKey] = true
+}
+
+// SetHeartbeat (default: 20 seconds) sets the interval of the keep-alive heartbeats of streams.
+func (s *Server) SetHeartbeat(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.heartbeat = d
+}
+
+// SetClock (default: twitter.SystemClock) sets the clock of the rate limit windows and the heartbeats
+// of streams, e.g. to share a fake Clock with the client under test.
+func (s *Server) SetClock(clock twitter.Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clock = clock
+}
+
+// Publish adds the tweets to the dataset and sends them to the connected streams.
+// Filtered streams only receive the tweets matching their rules.
+func (s *Server) Publish(tweets ...*twitter.Tweet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tweets = append(s.tweets, tweets...)
+	for _, t := range tweets {
+		for c, filtered := range s.streams {
+			data := &twitter.StreamData{Data: t}
+			if filtered {
+				for _, r := range s.rules {
+					if s.matches(t, r.Value) {
+						data.MatchingRules = append(data.MatchingRules, &twitter.RulesData{ID: r.ID, Tag: r.Tag})
+					}
+				}
+				if len(data.MatchingRules) == 0 {
+					continue
+				}
+			}
+
+			// slow consumers miss tweets, rather than blocking the server
+			select {
+			case c <- data:
+			default:
+			}
+		}
+	}
+}
+
+// route returns the endpoint template of the request and its path parameters.
+func route(r *http.Request) (string, map[string]string) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	for _, route := range routes {
+		method := strings.SplitN(route, " ", 2)
+		if method[0] != r.Method {
+			continue
+		}
+
+		pattern := strings.Split(strings.Trim(method[1], "/"), "/")
+		if len(pattern) != len(parts) {
+			continue
+		}
+
+		params := make(map[string]string)
+		for i, p := range pattern {
+			if strings.HasPrefix(p, ":") {
+				params[p[1:]] = parts[i]
+			} else if p != parts[i] {
+				params = nil
+				break
+			}
+		}
+
+		if params != nil {
+			return method[0] + " " + strings.TrimPrefix(method[1], "/2"), params
+		}
+	}
+
+	return "", nil
+}
+
+// serveHTTP routes the request, enforcing authentication, rate limits and failures.
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	endpoint, params := route(r)
+	if endpoint == "" {
+		problem(w, http.StatusNotFound, "Not Found Error", "Sorry, that page does not exist.")
+		return
+	}
+
+	// each App gets its consumer key as access token
+	if endpoint == "POST /oauth2/token" {
+		token := "twittertest"
+		if key, _, _ := r.BasicAuth(); key != "" {
+			token = key
+		}
+		writeJSON(w, map[string]string{"token_type": "bearer", "access_token": token})
+		return
+	}
+
+	if r.Header.Get("Authorization") == "" || s.isRevoked(r.Header.Get("Authorization")) {
+		problem(w, http.StatusUnauthorized, "Unauthorized", "Unauthorized")
+		return
+	}
+
+	if !s.limit(w, endpoint) {
+		return
+	}
+
+	switch endpoint {
+	case "GET /1.1/application/rate_limit_status.json":
+		s.rateLimitStatus(w)
+	case "GET /users":
+		s.lookup(w, r, "user", "ids", func(id string) interface{} { return s.user(id) })
+	case "GET /users/by":
+		s.lookup(w, r, "user", "usernames", func(username string) interface{} { return s.userByUserName(username) })
+	case "GET /users/me":
+		s.single(w, "user", s.me, s.user(s.me))
+	case "GET /users/by/username/:username":
+		s.single(w, "user", params["username"], s.userByUserName(params["username"]))
+	case "GET /users/:id":
+		s.single(w, "user", params["id"], s.user(params["id"]))
+	case "GET /users/:id/followers":
+		s.follows(w, r, endpoint, params["id"], s.followers)
+	case "GET /users/:id/following":
+		s.follows(w, r, endpoint, params["id"], s.following)
+	case "GET /users/:id/tweets":
+		s.timeline(w, r, endpoint, params["id"], func(t *twitter.Tweet) bool { return t.AuthorID == params["id"] })
+	case "GET /users/:id/mentions":
+		user := s.user(params["id"])
+		s.timeline(w, r, endpoint, params["id"], func(t *twitter.Tweet) bool {
+			return user != nil && strings.Contains(strings.ToLower(t.Text), "@"+strings.ToLower(user.UserName))
+		})
+	case "GET /tweets":
+		s.lookup(w, r, "tweet", "ids", func(id string) interface{} { return s.tweet(id) })
+	case "GET /tweets/:id":
+		s.single(w, "tweet", params["id"], s.tweet(params["id"]))
+	case "GET /tweets/search/recent", "GET /tweets/search/all":
+		s.search(w, r, endpoint)
+	case "GET /tweets/search/stream/rules":
+		s.getRules(w)
+	case "POST /tweets/search/stream/rules":
+		s.postRules(w, r)
+	case "GET /tweets/search/stream":
+		s.stream(w, r, true)
+	case "GET /tweets/sample/stream":
+		s.stream(w, r, false)
+	}
+}
+
+// isRevoked reports whether the authorization header carries a revoked token.
+func (s *Server) isRevoked(authorization string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.revoked[strings.TrimPrefix(authorization, "Bearer ")]
+}
+
+// limit sets the rate limit headers of the endpoint, failing the request if the budget
+// is exhausted or a failure was injected, and reports whether to proceed.
+func (s *Server) limit(w http.ResponseWriter, endpoint string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, ok := s.limits[endpoint]
+	if !ok {
+		l = &limit{limit: DefaultRateLimit, window: DefaultWindow, remaining: DefaultRateLimit}
+		s.limits[endpoint] = l
+	}
+
+	now := s.clock.Now()
+	if l.reset.IsZero() || now.After(l.reset) {
+		l.remaining, l.reset = l.limit, now.Add(l.window)
+	}
+
+	// injected failures
+	status := 0
+	if faults := s.faults[endpoint]; len(faults) > 0 {
+		status, s.faults[endpoint] = faults[0], faults[1:]
+		if status == http.StatusTooManyRequests {
+			l.remaining = 0
+		}
+	}
+
+	if status == 0 && l.remaining <= 0 {
+		status = http.StatusTooManyRequests
+	}
+	if status == 0 {
+		l.remaining--
+	}
+
+	w.Header().Set("x-rate-limit-limit", strconv.Itoa(l.limit))
+	w.Header().Set("x-rate-limit-remaining", strconv.Itoa(l.remaining))
+	w.Header().Set("x-rate-limit-reset", strconv.FormatInt(l.reset.Unix(), 10))
+
+	switch status {
+	case 0:
+		return true
+	case http.StatusTooManyRequests:
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(l.reset.Sub(now).Seconds()))))
+		problem(w, status, "Too Many Requests", "Too Many Requests")
+	default:
+		problem(w, status, http.StatusText(status), http.StatusText(status))
+	}
+	return false
+}
+
+// rateLimitStatus writes the rate limit status of the endpoints requested so far.
+func (s *Server) rateLimitStatus(w http.ResponseWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resources := make(map[string]map[string]interface{})
+	for endpoint, l := range s.limits {
+		path := strings.SplitN(endpoint, " ", 2)[1]
+		resource := strings.SplitN(strings.Trim(path, "/"), "/", 2)[0]
+		if resources[resource] == nil {
+			resources[resource] = make(map[string]interface{})
+		}
+		resources[resource][path] = map[string]interface{}{
+			"limit":     l.limit,
+			"remaining": l.remaining,
+			"reset":     l.reset.Unix(),
+		}
+	}
+
+	writeJSON(w, map[string]interface{}{"resources": resources})
+}
+
+// user returns the user with id, or nil.
+func (s *Server) user(id string) *twitter.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.ID == id {
+			return u
+		}
+	}
+	return nil
+}
+
+// userByUserName returns the user with username, or nil.
+func (s *Server) userByUserName(username string) *twitter.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if strings.EqualFold(u.UserName, username) {
+			return u
+		}
+	}
+	return nil
+}
+
+// tweet returns the tweet with id, or nil.
+func (s *Server) tweet(id string) *twitter.Tweet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.tweets {
+		if t.ID == id {
+			return t
+		}
+	}
+	return nil
+}
+
+// single writes the object, or a not found error.
+func (s *Server) single(w http.ResponseWriter, resource, value string, object interface{}) {
+	if isNil(object) {
+		writeJSON(w, map[string]interface{}{"errors": []*twitter.Problem{notFound(resource, "id", value)}})
+		return
+	}
+	writeJSON(w, map[string]interface{}{"data": object})
+}
+
+// lookup writes the objects of the comma separated values of param, with an
+// error for each missing object.
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request, resource, param string, find func(string) interface{}) {
+	values := strings.Split(r.URL.Query().Get(param), ",")
+	if len(values) > 100 {
+		problem(w, http.StatusBadRequest, "Invalid Request", "One or more parameters to your request was invalid.")
+		return
+	}
+
+	var data []interface{}
+	var errors []*twitter.Problem
+	for _, v := range values {
+		if o := find(v); !isNil(o) {
+			data = append(data, o)
+		} else {
+			errors = append(errors, notFound(resource, strings.TrimSuffix(param, "s"), v))
+		}
+	}
+
+	res := make(map[string]interface{})
+	if len(data) > 0 {
+		res["data"] = data
+	}
+	if len(errors) > 0 {
+		res["errors"] = errors
+	}
+	writeJSON(w, res)
+}
+
+// follows writes a page of the followers or the following of the user.
+func (s *Server) follows(w http.ResponseWriter, r *http.Request, endpoint, id string, graph map[string][]string) {
+	s.mu.Lock()
+	ids := append([]string(nil), graph[id]...)
+	s.mu.Unlock()
+
+	var users []interface{}
+	for _, id := range ids {
+		if u := s.user(id); u != nil {
+			users = append(users, u)
+		}
+	}
+
+	s.page(w, r, endpoint+" "+id, "pagination_token", users, 100, 1, 1000)
+}
+
+// timeline writes a page of the tweets matching match, most recent first.
+func (s *Server) timeline(w http.ResponseWriter, r *http.Request, endpoint, id string, match func(*twitter.Tweet) bool) {
+	if s.user(id) == nil {
+		writeJSON(w, map[string]interface{}{"errors": []*twitter.Problem{notFound("user", "id", id)}})
+		return
+	}
+
+	s.page(w, r, endpoint+" "+id, "pagination_token", s.recent(match), 10, 5, 100)
+}
+
+// search writes a page of the tweets matching the query, most recent first.
+func (s *Server) search(w http.ResponseWriter, r *http.Request, endpoint string) {
+	query := r.URL.Query().Get("query")
+	if query == "" {
+		problem(w, http.StatusBadRequest, "Invalid Request", "The `query` query parameter can not be empty")
+		return
+	}
+
+	tweets := s.recent(func(t *twitter.Tweet) bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.matches(t, query)
+	})
+	s.page(w, r, endpoint+" "+query, "next_token", tweets, 10, 10, 100)
+}
+
+// recent returns the tweets matching match, most recent first.
+func (s *Server) recent(match func(*twitter.Tweet) bool) []interface{} {
+	s.mu.Lock()
+	tweets := append([]*twitter.Tweet(nil), s.tweets...)
+	s.mu.Unlock()
+
+	var matched []interface{}
+	for i := len(tweets) - 1; i >= 0; i-- {
+		if match(tweets[i]) {
+			matched = append(matched, tweets[i])
+		}
+	}
+	return matched
+}
+
+// matches reports whether the tweet matches each term of the query, either
+// `from:username` or a case insensitive keyword. s.mu must be held.
+func (s *Server) matches(t *twitter.Tweet, query string) bool {
+	for _, term := range strings.Fields(strings.ToLower(query)) {
+		if strings.HasPrefix(term, "from:") {
+			var author *twitter.User
+			for _, u := range s.users {
+				if u.ID == t.AuthorID {
+					author = u
+				}
+			}
+			if author == nil || strings.ToLower(author.UserName) != strings.TrimPrefix(term, "from:") {
+				return false
+			}
+			continue
+		}
+
+		if !strings.Contains(strings.ToLower(t.Text), term) {
+			return false
+		}
+	}
+	return true
+}
+
+// page writes the page of objects selected by the token parameter and `max_results`,
+// with the token of the next page in the response's meta.
+func (s *Server) page(w http.ResponseWriter, r *http.Request, key, tokenParam string, objects []interface{}, size, min, max int) {
+	q := r.URL.Query()
+
+	if v := q.Get("max_results"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < min || n > max {
+			problem(w, http.StatusBadRequest, "Invalid Request",
+				fmt.Sprintf("The `max_results` query parameter value [%s] is not between %d and %d", v, min, max))
+			return
+		}
+		size = n
+	}
+
+	offset := 0
+	if token := q.Get(tokenParam); token != "" {
+		s.mu.Lock()
+		o, ok := s.tokens[key+" "+token]
+		s.mu.Unlock()
+		if !ok {
+			problem(w, http.StatusBadRequest, "Invalid Request",
+				fmt.Sprintf("The `%s` query parameter value [%s] is not valid", tokenParam, token))
+			return
+		}
+		offset = o
+	}
+
+	end := offset + size
+	if end > len(objects) {
+		end = len(objects)
+	}
+	if offset > end {
+		offset = end
+	}
+
+	meta := map[string]interface{}{"result_count": end - offset}
+	if end < len(objects) {
+		meta["next_token"] = s.token(key, end)
+	}
+
+	res := map[string]interface{}{"meta": meta}
+	if end > offset {
+		res["data"] = objects[offset:end]
+	}
+	writeJSON(w, res)
+}
+
+// token returns a new opaque pagination token for the offset of key.
+func (s *Server) token(key string, offset int) string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	token := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[key+" "+token] = offset
+	return token
+}
+
+// getRules writes the rules of the filtered stream.
+func (s *Server) getRules(w http.ResponseWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := map[string]interface{}{
+		"meta": map[string]interface{}{"sent": time.Now().UTC().Format(time.RFC3339Nano), "result_count": len(s.rules)},
+	}
+	if len(s.rules) > 0 {
+		res["data"] = s.rules
+	}
+	writeJSON(w, res)
+}
+
+// postRules adds or deletes rules of the filtered stream.
+func (s *Server) postRules(w http.ResponseWriter, r *http.Request) {
+	var rules twitter.Rules
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		problem(w, http.StatusBadRequest, "Invalid Request", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	summary := &twitter.RulesSummary{}
+	var created []*twitter.RulesData
+	for _, rule := range rules.Add {
+		s.ruleID++
+		added := &twitter.RulesData{ID: strconv.Itoa(s.ruleID), Value: rule.Value, Tag: rule.Tag}
+		s.rules = append(s.rules, added)
+		created = append(created, added)
+		summary.Created++
+	}
+
+	if rules.Delete != nil {
+		for _, id := range rules.Delete.Ids {
+			deleted := false
+			for i, rule := range s.rules {
+				if rule.ID == id {
+					s.rules = append(s.rules[:i], s.rules[i+1:]...)
+					deleted = true
+					break
+				}
+			}
+			if deleted {
+				summary.Deleted++
+			} else {
+				summary.NotDeleted++
+			}
+		}
+	}
+
+	res := map[string]interface{}{
+		"meta": &twitter.RulesMeta{Sent: time.Now().UTC(), Summary: summary},
+	}
+	if len(created) > 0 {
+		res["data"] = created
+	}
+	writeJSON(w, res)
+}
+
+// stream writes the published tweets to the connection, with heartbeats,
+// until the client disconnects.
+func (s *Server) stream(w http.ResponseWriter, r *http.Request, filtered bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		problem(w, http.StatusInternalServerError, "Internal Error", "streaming unsupported")
+		return
+	}
+
+	c := make(chan *twitter.StreamData, 64)
+
+	s.mu.Lock()
+	s.streams[c] = filtered
+	heartbeat, clock := s.heartbeat, s.clock
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.streams, c)
+		s.mu.Unlock()
+	}()
+
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	timer := clock.NewTimer(heartbeat)
+	defer timer.Stop()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-timer.C():
+			w.Write([]byte("\r\n"))
+			timer.Reset(heartbeat)
+		case data := <-c:
+			enc.Encode(data)
+		}
+		flusher.Flush()
+	}
+}
+
+// notFound returns the problem of a missing resource
+func notFound(resource, parameter, value string) *twitter.Problem {
+	return &twitter.Problem{
+		Value:        value,
+		Detail:       fmt.Sprintf("Could not find %s with %ss: [%s].", resource, parameter, value),
+		Title:        "Not Found Error",
+		ResourceType: resource,
+		Parameter:    parameter + "s",
+		ResourceID:   value,
+		Type:         "https://api.twitter.com/2/problems/resource-not-found",
+	}
+}
+
+// problem writes an error response
+func problem(w http.ResponseWriter, status int, title, detail string) {
+	res := map[string]interface{}{
+		"title":  title,
+		"detail": detail,
+		"type":   "about:blank",
+		"status": status,
+	}
+	// invalid requests detail each error
+	if status == http.StatusBadRequest {
+		res["type"] = "https://api.twitter.com/2/problems/invalid-request"
+		res["errors"] = []*twitter.Problem{{Message: detail}}
+	}
+
+	w.WriteHeader(status)
+	writeJSON(w, res)
+}
+
+// writeJSON writes v as json
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	json.NewEncoder(w).Encode(v)
+}
+
+// isNil reports whether the object is missing
+func isNil(o interface{}) bool {
+	switch v := o.(type) {
+	case *twitter.User:
+		return v == nil
+	case *twitter.Tweet:
+		return v == nil
+	}
+	return o == nil
+}
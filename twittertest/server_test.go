@@ -0,0 +1,118 @@
+package twittertest_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/cvcio/twitter"
+	"github.com/cvcio/twitter/twittertest"
+)
+
+func newClient(t *testing.T, server *twittertest.Server) *twitter.Twitter {
+	api, err := twitter.NewTwitter("key", "secret", server.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("Couldn't create Twitter API HTTP Client")
+	}
+	return api
+}
+
+func Test_Followers(t *testing.T) {
+	server := twittertest.NewServer()
+	defer server.Close()
+
+	server.AddUsers(&twitter.User{ID: "1", UserName: "andefined"})
+	for i := 2; i <= 251; i++ {
+		server.AddUsers(&twitter.User{ID: fmt.Sprint(i), UserName: fmt.Sprintf("user%d", i)})
+		server.Follow(fmt.Sprint(i), "1")
+	}
+
+	// the second page fails once and is retried
+	server.Fail("GET /users/:id/followers", 503, 1)
+	policy := twitter.RetryPolicy{MaxAttempts: 2, BaseBackoff: time.Millisecond}
+
+	api := newClient(t, server)
+	res := api.GetUserFollowers("1", url.Values{"max_results": {"100"}}, twitter.WithRetryPolicy(policy))
+
+	pages, followers := 0, 0
+	for r := range res {
+		if r.Err != nil {
+			t.Fatalf("Twitter API Error: %v", r.Err)
+		}
+
+		var users []*twitter.User
+		b, _ := json.Marshal(r.Page.Data)
+		json.Unmarshal(b, &users)
+
+		pages++
+		followers += len(users)
+	}
+
+	if pages != 3 || followers != 250 {
+		t.Fatalf("twittertest Error. Should have returned 250 followers in 3 pages, got %d in %d", followers, pages)
+	}
+}
+
+func Test_RateLimit(t *testing.T) {
+	server := twittertest.NewServer()
+	defer server.Close()
+
+	server.AddUsers(&twitter.User{ID: "1", UserName: "andefined"})
+	server.SetRateLimit("GET /users/:id", 10, time.Minute)
+	server.Fail("GET /users/:id", 429, 1)
+
+	api := newClient(t, server)
+	if r := <-api.GetUserByID("1", url.Values{}, twitter.WithAuto(false)); !twitter.IsRateLimited(r.Err) {
+		t.Fatalf("twittertest Error. Should have been rate limited, got %v", r.Err)
+	}
+
+	credentials, err := api.VerifyCredentials()
+	if err != nil {
+		t.Fatalf("Twitter API Error: %v", err)
+	}
+
+	for _, l := range credentials.RateLimits {
+		if l.Endpoint == "/users/:id" && (l.Limit != 10 || l.Remaining != 0) {
+			t.Fatalf("twittertest Error. Should have exhausted the budget, got %v", l)
+		}
+	}
+}
+
+func Test_FilterStream(t *testing.T) {
+	server := twittertest.NewServer()
+	defer server.Close()
+	server.SetHeartbeat(10 * time.Millisecond)
+
+	api := newClient(t, server)
+
+	rules := new(twitter.Rules)
+	rules.Add = append(rules.Add, &twitter.RulesData{Value: "greece", Tag: "test"})
+	if _, err := api.PostFilterStreamRules(nil, rules); err != nil {
+		t.Fatalf("Twitter API Error: %v", err)
+	}
+
+	s, err := api.GetFilterStream(url.Values{})
+	if err != nil {
+		t.Fatalf("Twitter API Error: %v", err)
+	}
+	defer s.Stop()
+
+	// wait for a few heartbeats
+	time.Sleep(50 * time.Millisecond)
+	server.Publish(
+		&twitter.Tweet{ID: "1", Text: "Hello world"},
+		&twitter.Tweet{ID: "2", Text: "Hello Greece"},
+	)
+
+	select {
+	case d := <-s.C:
+		data := d.(twitter.StreamData)
+		if data.Data == nil || data.Data.ID != "2" || len(data.MatchingRules) != 1 || data.MatchingRules[0].Tag != "test" {
+			t.Fatalf("twittertest Error. Should have streamed the matching tweet, got %v", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("twittertest Error. Should have streamed the matching tweet")
+	}
+}
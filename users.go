@@ -11,68 +11,23 @@ import (
 // Official Documentation: https://developer.twitter.com/en/docs/twitter-api/users/follows/api-reference/get-users-id-followers
 // Authentication Methods: OAuth 1.0a User Context, OAuth 2.0 Bearer Token
 // Rate Limit: 15/15m (app), 15/15m (user)
-func (api *Twitter) GetUserFollowers(id string, v url.Values, options ...QueueOption) (chan *Data, chan error) {
+func (api *Twitter) GetUserFollowers(id string, v url.Values, options ...QueueOption) chan Result {
 	// create the queue to process requests
 	queue := NewQueue(15*time.Minute/15, 15*time.Minute, true, make(chan *Request), make(chan *Response), options...)
-	// create the temp results channel
-	data := make(chan *Data)
-	errors := make(chan error)
 	// create the request object
 	request, _ := NewRquest("GET", fmt.Sprintf("%s/users/%s/followers", api.baseURL, id), v, nil)
-	// start the requests channel processor
-	go queue.processRequests(api)
-	// add the 1st request to the channel
-	queue.requestsChannel <- request
-
-	// async process the response channel
-	go (func(q *Queue, d chan *Data, e chan error, req *Request) {
-		// on done close channels
-		// close data channel
-		defer close(d)
-		// close error channel
-		defer close(e)
-
-		// listen channel
-		for {
-			// capture the response and channel state
-			res, ok := <-q.responseChannel
-			// break the loop if the channel is closed
-			if !ok {
-				break
-			}
-
-			// send the results to the data channel
-			d <- &res.Results
-			// send errors to error channel
-			if res.Error != nil {
-				e <- res.Error
-			}
-
-			// if there is a next page, transform the original request object
-			// by setting the `pagination_token` parameter to get the next page
-			if res.Results.Meta != nil && res.Results.Meta.NextToken != "" && q.auto {
-				// create new url values and add the pagination token
-				nv := url.Values{}
-				nv.Add("pagination_token", res.Results.Meta.NextToken)
-
-				// update request's url Values
-				req.UpdateURLValues(nv)
-				// reset request's results
-				req.ResetResults()
-
-				// add next request to the channel
-				q.requestsChannel <- req
-
-				//go to start
-				continue
-			}
-			// we are done! break the loop and close the channels
-			break
-		}
-	})(queue, data, errors, request)
+	request.Endpoint = "/users/:id/followers"
+	// start the requests channel processor and return the results channel
+	return queue.run(api, request, "pagination_token")
+}
 
-	// return the data channel
-	return data, errors
+// GetUserFollowersPaginator is like GetUserFollowers, but returns a Paginator to fetch the pages one at a time.
+func (api *Twitter) GetUserFollowersPaginator(id string, v url.Values, options ...QueueOption) *Paginator {
+	// create the request object
+	request, _ := NewRquest("GET", fmt.Sprintf("%s/users/%s/followers", api.baseURL, id), v, nil)
+	request.Endpoint = "/users/:id/followers"
+	// create the paginator, following the `pagination_token` of each page
+	return newPaginator(api, request, "pagination_token", 15*time.Minute/15, 15*time.Minute, options...)
 }
 
 // GetUserFollowing returns a list of users the specified user ID is following.
@@ -80,68 +35,23 @@ func (api *Twitter) GetUserFollowers(id string, v url.Values, options ...QueueOp
 // Official Documentation: https://developer.twitter.com/en/docs/twitter-api/users/follows/api-reference/get-users-id-following
 // Authentication Methods: OAuth 1.0a User Context, OAuth 2.0 Bearer Token
 // Rate Limit: 15/15m (app), 15/15m (user)
-func (api *Twitter) GetUserFollowing(id string, v url.Values, options ...QueueOption) (chan *Data, chan error) {
+func (api *Twitter) GetUserFollowing(id string, v url.Values, options ...QueueOption) chan Result {
 	// create the queue to process requests
 	queue := NewQueue(15*time.Minute/15, 15*time.Minute, true, make(chan *Request), make(chan *Response), options...)
-	// create the temp results channel
-	data := make(chan *Data)
-	errors := make(chan error)
 	// create the request object
 	request, _ := NewRquest("GET", fmt.Sprintf("%s/users/%s/following", api.baseURL, id), v, nil)
-	// start the requests channel processor
-	go queue.processRequests(api)
-	// add the 1st request to the channel
-	queue.requestsChannel <- request
-
-	// async process the response channel
-	go (func(q *Queue, d chan *Data, e chan error, req *Request) {
-		// on done close channels
-		// close data channel
-		defer close(d)
-		// close error channel
-		defer close(e)
-
-		// listen channel
-		for {
-			// capture the response and channel state
-			res, ok := <-q.responseChannel
-			// break the loop if the channel is closed
-			if !ok {
-				break
-			}
-
-			// send the results to the data channel
-			d <- &res.Results
-			// send errors to error channel
-			if res.Error != nil {
-				e <- res.Error
-			}
-
-			// if there is a next page, transform the original request object
-			// by setting the `pagination_token` parameter to get the next page
-			if res.Results.Meta != nil && res.Results.Meta.NextToken != "" && q.auto {
-				// create new url values and add the pagination token
-				nv := url.Values{}
-				nv.Add("pagination_token", res.Results.Meta.NextToken)
-
-				// update request's url Values
-				req.UpdateURLValues(nv)
-				// reset request's results
-				req.ResetResults()
-
-				// add next request to the channel
-				q.requestsChannel <- req
-
-				//go to start
-				continue
-			}
-			// we are done! break the loop and close the channels
-			break
-		}
-	})(queue, data, errors, request)
+	request.Endpoint = "/users/:id/following"
+	// start the requests channel processor and return the results channel
+	return queue.run(api, request, "pagination_token")
+}
 
-	// return the data channel
-	return data, errors
+// GetUserFollowingPaginator is like GetUserFollowing, but returns a Paginator to fetch the pages one at a time.
+func (api *Twitter) GetUserFollowingPaginator(id string, v url.Values, options ...QueueOption) *Paginator {
+	// create the request object
+	request, _ := NewRquest("GET", fmt.Sprintf("%s/users/%s/following", api.baseURL, id), v, nil)
+	request.Endpoint = "/users/:id/following"
+	// create the paginator, following the `pagination_token` of each page
+	return newPaginator(api, request, "pagination_token", 15*time.Minute/15, 15*time.Minute, options...)
 }
 
 // GetUsers returns a variety of information about one or more users specified by the requested IDs.
@@ -149,49 +59,14 @@ func (api *Twitter) GetUserFollowing(id string, v url.Values, options ...QueueOp
 // Official Documentation: https://developer.twitter.com/en/docs/twitter-api/users/lookup/api-reference/get-users
 // Authentication Methods: OAuth 1.0a User Context, OAuth 2.0 Bearer Token
 // Rate Limit: 300/15m (app), 900/15m (user)
-func (api *Twitter) GetUsers(v url.Values, options ...QueueOption) (chan *Data, chan error) {
+func (api *Twitter) GetUsers(v url.Values, options ...QueueOption) chan Result {
 	// create the queue to process requests
 	queue := NewQueue(15*time.Minute/15, 15*time.Minute, true, make(chan *Request), make(chan *Response), options...)
-	// create the temp results channel
-	data := make(chan *Data)
-	errors := make(chan error)
 	// create the request object
 	request, _ := NewRquest("GET", fmt.Sprintf("%s/users", api.baseURL), v, nil)
-	// start the requests channel processor
-	go queue.processRequests(api)
-	// add the 1st request to the channel
-	queue.requestsChannel <- request
-
-	// async process the response channel
-	go (func(q *Queue, d chan *Data, e chan error, req *Request) {
-		// on done close channels
-		// close data channel
-		defer close(d)
-		// close error channel
-		defer close(e)
-
-		// listen channel
-		for {
-			// capture the response and channel state
-			res, ok := <-q.responseChannel
-			// break the loop if the channel is closed
-			if !ok {
-				break
-			}
-
-			// send the results to the data channel
-			d <- &res.Results
-			// send errors to error channel
-			if res.Error != nil {
-				e <- res.Error
-			}
-			// we are done! break the loop and close the channels
-			break
-		}
-	})(queue, data, errors, request)
-
-	// return the data channel
-	return data, errors
+	request.Endpoint = "/users"
+	// start the requests channel processor and return the results channel
+	return queue.run(api, request, "")
 }
 
 // GetUsersByUserName returns a variety of information about one or more users specified by their usernames.
@@ -199,49 +74,14 @@ func (api *Twitter) GetUsers(v url.Values, options ...QueueOption) (chan *Data,
 // Official Documentation: https://developer.twitter.com/en/docs/twitter-api/users/lookup/api-reference/get-users-by
 // Authentication Methods: OAuth 1.0a User Context, OAuth 2.0 Bearer Token
 // Rate Limit: 300/15m (app), 900/15m (user)
-func (api *Twitter) GetUsersBy(v url.Values, options ...QueueOption) (chan *Data, chan error) {
+func (api *Twitter) GetUsersBy(v url.Values, options ...QueueOption) chan Result {
 	// create the queue to process requests
 	queue := NewQueue(15*time.Minute/15, 15*time.Minute, true, make(chan *Request), make(chan *Response), options...)
-	// create the temp results channel
-	data := make(chan *Data)
-	errors := make(chan error)
 	// create the request object
 	request, _ := NewRquest("GET", fmt.Sprintf("%s/users/by", api.baseURL), v, nil)
-	// start the requests channel processor
-	go queue.processRequests(api)
-	// add the 1st request to the channel
-	queue.requestsChannel <- request
-
-	// async process the response channel
-	go (func(q *Queue, d chan *Data, e chan error, req *Request) {
-		// on done close channels
-		// close data channel
-		defer close(d)
-		// close error channel
-		defer close(e)
-
-		// listen channel
-		for {
-			// capture the response and channel state
-			res, ok := <-q.responseChannel
-			// break the loop if the channel is closed
-			if !ok {
-				break
-			}
-
-			// send the results to the data channel
-			d <- &res.Results
-			// send errors to error channel
-			if res.Error != nil {
-				e <- res.Error
-			}
-			// we are done! break the loop and close the channels
-			break
-		}
-	})(queue, data, errors, request)
-
-	// return the data channel
-	return data, errors
+	request.Endpoint = "/users/by"
+	// start the requests channel processor and return the results channel
+	return queue.run(api, request, "")
 }
 
 // GetUserByID returns a variety of information about a single user specified by the requested ID.
@@ -249,50 +89,14 @@ func (api *Twitter) GetUsersBy(v url.Values, options ...QueueOption) (chan *Data
 // Official Documentation: https://developer.twitter.com/en/docs/twitter-api/users/lookup/api-reference/get-users-id
 // Authentication Methods: OAuth 1.0a User Context, OAuth 2.0 Bearer Token
 // Rate Limit: 300/15m (app), 900/15m (user)
-func (api *Twitter) GetUserByID(id string, v url.Values, options ...QueueOption) (chan *Data, chan error) {
+func (api *Twitter) GetUserByID(id string, v url.Values, options ...QueueOption) chan Result {
 	// create the queue to process requests
 	queue := NewQueue(15*time.Minute/15, 15*time.Minute, true, make(chan *Request), make(chan *Response), options...)
-	// create the temp results channel
-	data := make(chan *Data)
-	errors := make(chan error)
 	// create the request object
 	request, _ := NewRquest("GET", fmt.Sprintf("%s/users/%s", api.baseURL, id), v, nil)
-	// start the requests channel processor
-	go queue.processRequests(api)
-	// add the 1st request to the channel
-	queue.requestsChannel <- request
-
-	// async process the response channel
-	go (func(q *Queue, d chan *Data, e chan error, req *Request) {
-		// on done close channels
-		// close data channel
-		defer close(d)
-		// close error channel
-		defer close(e)
-
-		// listen channel
-		for {
-			// capture the response and channel state
-			res, ok := <-q.responseChannel
-			// break the loop if the channel is closed
-			if !ok {
-				break
-			}
-
-			// send the results to the data channel
-			d <- &res.Results
-			// send errors to error channel
-			if res.Error != nil {
-				e <- res.Error
-			}
-
-			// we are done! break the loop and close the channels
-			break
-		}
-	})(queue, data, errors, request)
-
-	// return the data channel
-	return data, errors
+	request.Endpoint = "/users/:id"
+	// start the requests channel processor and return the results channel
+	return queue.run(api, request, "")
 }
 
 // GetUserByUserName returns a variety of information about one or more users specified by their usernames.
@@ -300,48 +104,12 @@ func (api *Twitter) GetUserByID(id string, v url.Values, options ...QueueOption)
 // Official Documentation: https://developer.twitter.com/en/docs/twitter-api/users/lookup/api-reference/get-users-by-username-username
 // Authentication Methods: OAuth 1.0a User Context, OAuth 2.0 Bearer Token
 // Rate Limit: 300/15m (app), 900/15m (user)
-func (api *Twitter) GetUsersByUserName(username string, v url.Values, options ...QueueOption) (chan *Data, chan error) {
+func (api *Twitter) GetUsersByUserName(username string, v url.Values, options ...QueueOption) chan Result {
 	// create the queue to process requests
 	queue := NewQueue(15*time.Minute/15, 15*time.Minute, true, make(chan *Request), make(chan *Response), options...)
-	// create the temp results channel
-	data := make(chan *Data)
-	errors := make(chan error)
 	// create the request object
 	request, _ := NewRquest("GET", fmt.Sprintf("%s/users/by/username/%s", api.baseURL, username), v, nil)
-	// start the requests channel processor
-	go queue.processRequests(api)
-	// add the 1st request to the channel
-	queue.requestsChannel <- request
-
-	// async process the response channel
-	go (func(q *Queue, d chan *Data, e chan error, req *Request) {
-		// on done close channels
-		// close data channel
-		defer close(d)
-		// close error channel
-		defer close(e)
-
-		// listen channel
-		for {
-			// capture the response and channel state
-			res, ok := <-q.responseChannel
-			// break the loop if the channel is closed
-			if !ok {
-				break
-			}
-
-			// send the results to the data channel
-			d <- &res.Results
-			// send errors to error channel
-			if res.Error != nil {
-				e <- res.Error
-			}
-
-			// we are done! break the loop and close the channels
-			break
-		}
-	})(queue, data, errors, request)
-
-	// return the data channel
-	return data, errors
+	request.Endpoint = "/users/by/username/:username"
+	// start the requests channel processor and return the results channel
+	return queue.run(api, request, "")
 }